@@ -0,0 +1,151 @@
+package climate
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/uxff/topograph-maker/terrain"
+	"gopkg.in/yaml.v2"
+)
+
+// 生物群系
+type Biome uint8
+
+const (
+	BiomeOcean Biome = iota
+	BiomeBeach
+	BiomeDesert
+	BiomeGrassland
+	BiomeSavanna
+	BiomeForest
+	BiomeRainforest
+	BiomeTaiga
+	BiomeTundra
+	BiomeSnow
+	BiomeSwamp
+	BiomeNum // 群系的个数
+)
+
+// 群系名 用于配置调色板
+var BiomeNames = [BiomeNum]string{"ocean", "beach", "desert", "grassland", "savanna", "forest", "rainforest", "taiga", "tundra", "snow", "swamp"}
+
+func (b Biome) String() string {
+	if b < BiomeNum {
+		return BiomeNames[b]
+	}
+	return fmt.Sprintf("biome(%d)", b)
+}
+
+// 由名字得到群系
+func ParseBiome(name string) (Biome, error) {
+	for b, n := range BiomeNames {
+		if n == name {
+			return Biome(b), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown biome: %s", name)
+}
+
+// 按Whittaker图分类的参数 降水单位为厘米每年
+type BiomeConfig struct {
+	Precip  float64           // 降水倍数为1时的年降水 默认100
+	Beach   float64           // 海平面以上此高度内的陆地为海滩 默认0.5
+	Lowland float64           // 海平面以上此高度内且降水多的为沼泽 默认3
+	Swamp   float64           // 低地年降水超过此值为沼泽 默认200
+	Snow    float64           // 年均温低于此值为冰雪 默认-10
+	Dryness float64           // 空气湿度对有效降水的影响 0-1 有效降水=降水*(1-k+2k*湿度) 默认0.5
+	Palette map[string]string // 群系名到颜色 #rrggbb 未配置的用默认颜色
+}
+
+func (c *BiomeConfig) setDefaults() {
+	defaults := []struct {
+		v   *float64
+		def float64
+	}{
+		{&c.Precip, 100}, {&c.Beach, 0.5}, {&c.Lowland, 3}, {&c.Swamp, 200}, {&c.Dryness, 0.5},
+	}
+	for _, d := range defaults {
+		if *d.v <= 0 {
+			*d.v = d.def
+		}
+	}
+	if c.Snow == 0 {
+		c.Snow = -10
+	}
+}
+
+// 从yaml文件读取群系配置
+func LoadBiomeConfig(file string) (*BiomeConfig, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c := &BiomeConfig{}
+	if err = yaml.Unmarshal(content, c); err != nil {
+		return nil, err
+	}
+	for name := range c.Palette {
+		if _, err := ParseBiome(name); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// 由海拔 年均温和降水倍数得到每点的群系 rain为nil时按均匀降水
+// humidity为水汽输送得到的空气湿度 干燥的空气蒸发强 有效降水少 为nil时不考虑
+func (c BiomeConfig) Classify(m *terrain.Topomap, temp, rain, humidity []float32) []Biome {
+	c.setDefaults()
+	biomes := make([]Biome, len(m.Data))
+	m.EachRowBand(func(_, y0, y1 int) {
+		for idx := y0 * m.Width; idx < y1*m.Width; idx++ {
+			p := c.Precip
+			if rain != nil {
+				p *= float64(rain[idx])
+			}
+			if humidity != nil {
+				p *= 1 - c.Dryness + 2*c.Dryness*float64(humidity[idx])
+			}
+			biomes[idx] = c.classify(float64(m.Data[idx]-m.SeaLevel), float64(temp[idx]), p)
+		}
+	})
+	return biomes
+}
+
+// elev为相对海平面的高度 t为年均温 p为年降水
+func (c BiomeConfig) classify(elev, t, p float64) Biome {
+	switch {
+	case elev < 0:
+		return BiomeOcean
+	case t < c.Snow:
+		return BiomeSnow
+	case t < 0:
+		return BiomeTundra
+	case elev < c.Beach:
+		return BiomeBeach
+	case elev < c.Lowland && p >= c.Swamp:
+		return BiomeSwamp
+	case t < 7:
+		if p < 30 {
+			return BiomeTundra
+		}
+		return BiomeTaiga
+	case t < 20:
+		if p < 25 {
+			return BiomeDesert
+		}
+		if p < 75 {
+			return BiomeGrassland
+		}
+		return BiomeForest
+	}
+	switch {
+	case p < 25:
+		return BiomeDesert
+	case p < 100:
+		return BiomeSavanna
+	case p < 180:
+		return BiomeForest
+	}
+	return BiomeRainforest
+}
@@ -0,0 +1,125 @@
+// Package climate 由地形得到的气候场 降水 风和湿度等
+package climate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 降水类型
+const (
+	RainUniform    = "uniform"    // 均匀
+	RainNoise      = "noise"      // 噪声 成片的干湿区
+	RainLatitude   = "latitude"   // 按纬度分带 赤道和中纬度多雨 副热带和极地干燥
+	RainOrographic = "orographic" // 地形雨 盛行风的迎风坡多雨 背风坡和内陆干燥
+	RainPng        = "png"        // 读取灰度图 越白降水越多
+)
+
+// 降水场配置 得到每点的降水倍数 用于汇流权重 浅水模拟降水和水滴投放
+type RainConfig struct {
+	Type      string  // uniform|noise|latitude|orographic|png 为空等同uniform
+	Amount    float64 // 平均降水倍数 png为最白处的倍数 默认1
+	Scale     float64 // noise的特征尺寸 单位像素 默认128
+	Variation float64 // noise的起伏幅度 0-1 默认0.8
+	North     float64 // latitude 地图上边缘的纬度 默认90
+	South     float64 // latitude 地图下边缘的纬度 默认-90
+	Wind      float64 // orographic 风吹去的方向 角度 0向右(东) 90向下(南) 默认0
+	Height    float64 // orographic 水汽容量随海拔减小的特征高度 默认20 越小山地降水越集中
+	File      string  // png文件
+}
+
+func (c *RainConfig) setDefaults() {
+	if c.Amount <= 0 {
+		c.Amount = 1
+	}
+	if c.Scale <= 0 {
+		c.Scale = 128
+	}
+	if c.Variation <= 0 || c.Variation > 1 {
+		c.Variation = 0.8
+	}
+	if c.North == 0 && c.South == 0 {
+		c.North, c.South = 90, -90
+	}
+}
+
+// 生成降水场 除png外陆地上的平均值为Amount
+// orographic时同时返回水汽输送的结果 其中的湿度可用于群系分类 其他类型atmo为nil
+func (c RainConfig) Map(rnd *rand.Rand, m *terrain.Topomap) (rain []float32, atmo *Atmosphere, err error) {
+	c.setDefaults()
+	rain = make([]float32, len(m.Data))
+	switch c.Type {
+	case "", RainUniform:
+		for i := range rain {
+			rain[i] = 1
+		}
+	case RainNoise:
+		g, err := terrain.NewGenerator(rnd, terrain.NoiseConfig{Type: "fbm", Scale: c.Scale}, m.Width, m.Height)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.EachRowBand(func(_, y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := 0; x < m.Width; x++ {
+					rain[x+y*m.Width] = float32(1 - c.Variation + 2*c.Variation*g.Height(x, y))
+				}
+			}
+		})
+	case RainLatitude:
+		for y := 0; y < m.Height; y++ {
+			lat := Latitude(y, m.Height, c.North, c.South) * math.Pi / 180
+			// 0和60度最多 30和90度最少 两极整体偏干
+			r := float32((0.6 + 0.4*math.Cos(6*lat)) * (0.5 + 0.5*math.Cos(lat)))
+			for x := 0; x < m.Width; x++ {
+				rain[x+y*m.Width] = r
+			}
+		}
+	case RainOrographic:
+		atmo = AdvectMoisture(m, MoistureConfig{Wind: c.Wind, Scale: c.Height})
+		copy(rain, atmo.Rain)
+	case RainPng:
+		gray, err := terrain.LoadGrayPng(c.File, m.Width, m.Height)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, g := range gray {
+			rain[i] = g * float32(c.Amount)
+		}
+		return rain, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown rain type: %s", c.Type)
+	}
+	normalize(m, rain, float32(c.Amount))
+	return rain, atmo, nil
+}
+
+// 第y行中心的纬度 从north线性变化到south
+func Latitude(y, height int, north, south float64) float64 {
+	return north + (south-north)*(float64(y)+0.5)/float64(height)
+}
+
+// 缩放到陆地上的平均值为mean 没有陆地时按全图
+func normalize(m *terrain.Topomap, data []float32, mean float32) {
+	var sum, sumAll float64
+	var n int
+	for i, v := range data {
+		sumAll += float64(v)
+		if !m.IsSea(i) {
+			sum += float64(v)
+			n++
+		}
+	}
+	if n == 0 {
+		sum, n = sumAll, len(data)
+	}
+	if sum <= 0 {
+		return
+	}
+	k := mean * float32(float64(n)/sum)
+	for i := range data {
+		data[i] *= k
+	}
+}
@@ -0,0 +1,217 @@
+package climate
+
+import (
+	"math"
+	"sort"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 积雪和冰川参数 积累随降水和冰冻期增加 消融随夏季气温增加
+// 积累大于消融处终年积雪 冰川沿最陡方向流下 可以把谷底刨成U形谷
+type SnowConfig struct {
+	Accumulation float64 // 降水倍数为1且全年冰冻时的积累量 默认1
+	Melt         float64 // 最热月每高于0度1度的消融量 默认0.1
+	Iterations   int     // 冰川刨蚀的迭代次数 0表示只计算积雪不改变地形
+	Carve        float64 // 每次迭代冰川中心刨蚀的深度系数 默认0.05
+	Width        float64 // U形谷半宽与冰流量平方根的比例 默认1.5 最宽8
+	Glacier      float64 // 冰流量不小于此值才算冰川 才会刨蚀 默认5
+}
+
+func (c *SnowConfig) setDefaults() {
+	defaults := []struct {
+		v   *float64
+		def float64
+	}{
+		{&c.Accumulation, 1}, {&c.Melt, 0.1}, {&c.Carve, 0.05}, {&c.Width, 1.5}, {&c.Glacier, 5},
+	}
+	for _, d := range defaults {
+		if *d.v <= 0 {
+			*d.v = d.def
+		}
+	}
+}
+
+// 积雪和冰川
+type SnowCover struct {
+	Width   int
+	Height  int
+	Balance []float32 // 每点积累减消融 正值为积雪区
+	Snow    []float32 // 终年积雪的厚度 等于正的Balance
+	Ice     []float32 // 流经每点的冰流量
+	glacier float32
+}
+
+// 由气温和降水得到积雪 rain为nil时按均匀降水 气温没有最冷最热月时按年均温上下5度
+func NewSnowCover(m *terrain.Topomap, temp *Temperature, rain []float32, c SnowConfig) *SnowCover {
+	c.setDefaults()
+	s := &SnowCover{
+		Width: m.Width, Height: m.Height,
+		Balance: make([]float32, len(m.Data)), Snow: make([]float32, len(m.Data)), Ice: make([]float32, len(m.Data)),
+		glacier: float32(c.Glacier),
+	}
+	m.EachRowBand(func(_, y0, y1 int) {
+		for idx := y0 * m.Width; idx < y1*m.Width; idx++ {
+			if m.IsSea(idx) {
+				continue
+			}
+			winter, summer := float64(temp.Mean[idx])-5, float64(temp.Mean[idx])+5
+			if temp.Min != nil {
+				winter, summer = float64(temp.Min[idx]), float64(temp.Max[idx])
+			}
+			// 一年中低于0度的比例 气温按正弦在冬夏之间变化
+			frozen := 1.0
+			if summer > 0 {
+				frozen = 0
+				if winter < 0 {
+					frozen = math.Acos((summer+winter)/(summer-winter)) / math.Pi
+				}
+			}
+			p := c.Accumulation
+			if rain != nil {
+				p *= float64(rain[idx])
+			}
+			b := p*frozen - c.Melt*math.Max(summer, 0)
+			s.Balance[idx] = float32(b)
+			if b > 0 {
+				s.Snow[idx] = float32(b)
+			}
+		}
+	})
+	s.flowIce(m)
+	return s
+}
+
+// 是否有积雪或冰川
+func (s *SnowCover) Covered(idx int) bool {
+	return s.Snow[idx] > 0 || s.IsGlacier(idx)
+}
+
+// 冰流量是否达到冰川
+func (s *SnowCover) IsGlacier(idx int) bool {
+	return s.Ice[idx] >= s.glacier
+}
+
+// 积雪和冰川的遮罩
+func (s *SnowCover) Mask() []bool {
+	mask := make([]bool, len(s.Snow))
+	for idx := range mask {
+		mask[idx] = s.Covered(idx)
+	}
+	return mask
+}
+
+// 从高到低累加冰流量 每点把冰交给最低的邻居 冰流量加上消融(负的Balance)后不小于0
+// 没有更低的邻居时冰留在原地
+func (s *SnowCover) flowIce(m *terrain.Topomap) {
+	order := make([]int, len(m.Data))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ha, hb := m.Data[order[a]], m.Data[order[b]]
+		if ha != hb {
+			return ha > hb
+		}
+		return order[a] < order[b]
+	})
+	for i := range s.Ice {
+		s.Ice[i] = 0
+	}
+	for _, idx := range order {
+		if m.IsSea(idx) {
+			s.Ice[idx] = 0
+			continue
+		}
+		q := s.Ice[idx] + s.Balance[idx]
+		if q <= 0 {
+			s.Ice[idx] = 0
+			continue
+		}
+		s.Ice[idx] = q
+		if ni := lowestNeighbor(m, idx); ni >= 0 {
+			s.Ice[ni] += q
+		}
+	}
+}
+
+// 最低的比idx低的8邻居 没有时返回-1
+func lowestNeighbor(m *terrain.Topomap, idx int) int {
+	x, y := idx%m.Width, idx/m.Width
+	best, low := -1, m.Data[idx]
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			nx, ny := x+dx, y+dy
+			if (dx == 0 && dy == 0) || nx < 0 || ny < 0 || nx >= m.Width || ny >= m.Height {
+				continue
+			}
+			if ni := nx + ny*m.Width; m.Data[ni] < low {
+				best, low = ni, m.Data[ni]
+			}
+		}
+	}
+	return best
+}
+
+// 冰川刨蚀 每次迭代先按冰流量算出每点的下切量 再统一应用 然后重新计算冰流
+// 下切量在谷中按 1-(d/r)^4 分布 谷底平 谷壁陡 形成U形谷
+// 谷壁不会低于谷底 地形不会刨到海平面以下 返回刨蚀的总量
+func (s *SnowCover) Carve(m *terrain.Topomap, c SnowConfig) (carved float32) {
+	c.setDefaults()
+	cut := make([]float32, len(m.Data))
+	for it := 0; it < c.Iterations; it++ {
+		for i := range cut {
+			cut[i] = 0
+		}
+		for idx, q := range s.Ice {
+			if !s.IsGlacier(idx) {
+				continue
+			}
+			ni := lowestNeighbor(m, idx)
+			if ni < 0 {
+				continue
+			}
+			// 每次下切最多1 不超过与下游点落差的一半 不会刨出坑
+			depth := math.Min(c.Carve*math.Sqrt(float64(q)), 1)
+			if drop := float64(m.Data[idx]-m.Data[ni]) / 2; depth > drop {
+				depth = drop
+			}
+			floor := m.Data[idx] - float32(depth)
+			r := math.Min(math.Max(c.Width*math.Sqrt(float64(q)), 1), 8)
+			x, y, ir := idx%m.Width, idx/m.Width, int(r)
+			for dy := -ir; dy <= ir; dy++ {
+				for dx := -ir; dx <= ir; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= m.Width || ny >= m.Height {
+						continue
+					}
+					d := math.Hypot(float64(dx), float64(dy)) / r
+					if d >= 1 {
+						continue
+					}
+					bi := nx + ny*m.Width
+					v := float32(depth * (1 - d*d*d*d))
+					if over := m.Data[bi] - floor; v > over {
+						v = over
+					}
+					if over := m.Data[bi] - m.SeaLevel; v > over {
+						v = over
+					}
+					// 同一点取各段冰川中最深的下切 避免叠加
+					if v > cut[bi] {
+						cut[bi] = v
+					}
+				}
+			}
+		}
+		for idx, v := range cut {
+			if v > 0 {
+				old := m.Data[idx]
+				m.Lower(idx, v)
+				carved += old - m.Data[idx]
+			}
+		}
+		s.flowIce(m)
+	}
+	return carved
+}
@@ -0,0 +1,126 @@
+package climate
+
+import (
+	"math"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 气温参数 单位摄氏度 由纬度得到海平面气温 按海拔递减 靠海处年均温趋向海面温度 季节变化小
+type TemperatureConfig struct {
+	Equator float64 // 赤道海平面的年均温 默认27
+	Pole    float64 // 极地海平面的年均温 默认-25
+	Lapse   float64 // 每升高1单位地形高度降低的温度 默认0.6
+	North   float64 // 地图上边缘的纬度 默认90
+	South   float64 // 地图下边缘的纬度 默认-90
+	Season  float64 // 极地内陆的季节振幅 冬夏各偏离年均温这么多 赤道为0 0表示不计算最冷和最热月
+	Ocean   float64 // 海洋影响的强度 0-1 海边的年均温向海面温度靠拢这个比例 季节振幅削弱这个比例 默认0.6
+	Reach   float64 // 海洋影响随离海距离衰减的特征距离 单位像素 默认30
+	SeaMin  float64 // 海面年均温的下限 海水结冰前不会更冷 默认-2
+}
+
+func (c *TemperatureConfig) setDefaults() {
+	if c.Equator == 0 && c.Pole == 0 {
+		c.Equator, c.Pole = 27, -25
+	}
+	if c.Lapse <= 0 {
+		c.Lapse = 0.6
+	}
+	if c.North == 0 && c.South == 0 {
+		c.North, c.South = 90, -90
+	}
+	if c.Ocean <= 0 || c.Ocean > 1 {
+		c.Ocean = 0.6
+	}
+	if c.Reach <= 0 {
+		c.Reach = 30
+	}
+	if c.SeaMin == 0 {
+		c.SeaMin = -2
+	}
+}
+
+// 气温场
+type Temperature struct {
+	Width  int
+	Height int
+	Mean   []float32 // 年均温
+	Min    []float32 // 最冷月 Season为0时为nil
+	Max    []float32 // 最热月 Season为0时为nil
+}
+
+// 由地形计算气温 海面按海平面算 不低于SeaMin
+func NewTemperature(m *terrain.Topomap, c TemperatureConfig) *Temperature {
+	c.setDefaults()
+	t := &Temperature{Width: m.Width, Height: m.Height, Mean: make([]float32, len(m.Data))}
+	if c.Season > 0 {
+		t.Min, t.Max = make([]float32, len(m.Data)), make([]float32, len(m.Data))
+	}
+	ocean := seaDistance(m)
+	m.EachRowBand(func(_, y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			lat := Latitude(y, m.Height, c.North, c.South) * math.Pi / 180
+			base := c.Pole + (c.Equator-c.Pole)*math.Cos(lat)
+			sea := math.Max(base, c.SeaMin)
+			amp := c.Season * math.Abs(math.Sin(lat))
+			for x := 0; x < m.Width; x++ {
+				idx := x + y*m.Width
+				// 海洋的影响 海面为Ocean 向内陆衰减
+				near := c.Ocean * math.Exp(-float64(ocean[idx])/c.Reach)
+				mean := base + (sea-base)*near
+				if m.IsSea(idx) {
+					mean = sea
+				} else if h := m.Data[idx]; h > m.SeaLevel {
+					mean -= c.Lapse * float64(h-m.SeaLevel)
+				}
+				t.Mean[idx] = float32(mean)
+				if t.Min == nil {
+					continue
+				}
+				a := amp * (1 - near)
+				t.Min[idx], t.Max[idx] = float32(mean-a), float32(mean+a)
+			}
+		}
+	})
+	return t
+}
+
+// 每点到最近海面的距离 倒角距离变换 两遍扫描 没有海时为地图对角线长度
+func seaDistance(m *terrain.Topomap) []float32 {
+	const diag = 1.4142135
+	far := float32(m.Width + m.Height)
+	dist := make([]float32, len(m.Data))
+	for i := range dist {
+		if !m.IsSea(i) {
+			dist[i] = far
+		}
+	}
+	relax := func(idx, x, y int, dx, dy int, d float32) {
+		nx, ny := x+dx, y+dy
+		if nx < 0 || ny < 0 || nx >= m.Width || ny >= m.Height {
+			return
+		}
+		if v := dist[nx+ny*m.Width] + d; v < dist[idx] {
+			dist[idx] = v
+		}
+	}
+	for y := 0; y < m.Height; y++ {
+		for x := 0; x < m.Width; x++ {
+			idx := x + y*m.Width
+			relax(idx, x, y, -1, 0, 1)
+			relax(idx, x, y, 0, -1, 1)
+			relax(idx, x, y, -1, -1, diag)
+			relax(idx, x, y, 1, -1, diag)
+		}
+	}
+	for y := m.Height - 1; y >= 0; y-- {
+		for x := m.Width - 1; x >= 0; x-- {
+			idx := x + y*m.Width
+			relax(idx, x, y, 1, 0, 1)
+			relax(idx, x, y, 0, 1, 1)
+			relax(idx, x, y, 1, 1, diag)
+			relax(idx, x, y, -1, 1, diag)
+		}
+	}
+	return dist
+}
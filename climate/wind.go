@@ -0,0 +1,134 @@
+package climate
+
+import (
+	"math"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 水汽输送参数 盛行风把海上的水汽吹过地图
+// 空气能容纳的水汽随地面升高而减少 气流被地形抬升时超出的部分降下 翻过山后水汽不足形成雨影区
+type MoistureConfig struct {
+	Wind     float64 // 风吹去的方向 角度 0向右(东) 90向下(南)
+	Scale    float64 // 水汽容量随海拔按e指数减小的特征高度 单位和地形高度一致 默认20
+	Base     float64 // 平地上每格降下的比例 默认0.01
+	Recharge float64 // 经过海面时每格恢复的比例 默认0.05
+	Recycle  float64 // 陆地降水蒸发回到空气中的比例 0-1 默认0.3
+	Spread   float64 // 垂直风向的扩散 0-1 默认0.2
+}
+
+func (c *MoistureConfig) setDefaults() {
+	defaults := []struct {
+		v   *float64
+		def float64
+	}{
+		{&c.Scale, 20}, {&c.Base, 0.01}, {&c.Recharge, 0.05}, {&c.Recycle, 0.3}, {&c.Spread, 0.2},
+	}
+	for _, d := range defaults {
+		if *d.v <= 0 {
+			*d.v = d.def
+		}
+	}
+	if c.Recycle > 1 {
+		c.Recycle = 1
+	}
+	if c.Spread > 1 {
+		c.Spread = 1
+	}
+}
+
+// 水汽输送的结果
+type Atmosphere struct {
+	Width    int
+	Height   int
+	Rain     []float32 // 每点的降水量
+	Humidity []float32 // 降水后空气中剩余的水汽 0-1 上风边缘为1
+}
+
+// 水汽从上风边缘吹入 沿风向逐列(或逐行)推进 每列只读上风一列已算好的水汽
+func AdvectMoisture(m *terrain.Topomap, c MoistureConfig) *Atmosphere {
+	c.setDefaults()
+	a := &Atmosphere{
+		Width: m.Width, Height: m.Height,
+		Rain: make([]float32, len(m.Data)), Humidity: make([]float32, len(m.Data)),
+	}
+	base := float32(c.Base)
+	recharge, recycle, spread := float32(c.Recharge), float32(c.Recycle), float32(c.Spread)
+
+	wx, wy := math.Cos(c.Wind*math.Pi/180), math.Sin(c.Wind*math.Pi/180)
+	// u是推进的轴 v是垂直的轴 每推进一格 v方向偏移shift
+	nu, nv, du, dv := m.Width, m.Height, wx, wy
+	index := func(u, v int) int { return u + v*m.Width }
+	if math.Abs(wy) > math.Abs(wx) {
+		nu, nv, du, dv = m.Height, m.Width, wy, wx
+		index = func(u, v int) int { return v + u*m.Width }
+	}
+	step, shift := 1, float32(dv/math.Abs(du))
+	if du < 0 {
+		step = -1
+	}
+
+	// 海拔处空气的水汽容量 海面上为1
+	capacity := func(idx int) float32 {
+		if h := m.Data[idx]; h > m.SeaLevel {
+			return float32(math.Exp(-float64(h-m.SeaLevel) / c.Scale))
+		}
+		return 1
+	}
+	col := make([]float32, nv) // 当前列扩散前的水汽
+	for i := 0; i < nu; i++ {
+		u := i
+		if step < 0 {
+			u = nu - 1 - i
+		}
+		for v := 0; v < nv; v++ {
+			idx := index(u, v)
+			w := float32(1)
+			if i > 0 {
+				// 上风一格处的水汽和地面 在v方向线性插值
+				fv := float32(v) - shift
+				if fv < 0 {
+					fv = 0
+				} else if fv > float32(nv-1) {
+					fv = float32(nv - 1)
+				}
+				v0 := int(fv)
+				v1, t := v0+1, fv-float32(v0)
+				if v1 >= nv {
+					v1 = nv - 1
+				}
+				p, q := index(u-step, v0), index(u-step, v1)
+				w = a.Humidity[p]*(1-t) + a.Humidity[q]*t
+			}
+			// 超出容量的部分全部降下 另有少量平常的降水
+			r := w * base
+			if cp := capacity(idx); w-r > cp {
+				r = w - cp
+			}
+			a.Rain[idx] = r
+			w -= r
+			if m.IsSea(idx) {
+				w += (1 - w) * recharge
+			} else {
+				w += r * recycle
+			}
+			col[v] = w
+		}
+		// 垂直风向扩散 边缘按镜像
+		for v := 0; v < nv; v++ {
+			l, r := v-1, v+1
+			if l < 0 {
+				l = r
+			}
+			if r >= nv {
+				r = l
+			}
+			if l < 0 || r >= nv {
+				a.Humidity[index(u, v)] = col[v]
+				continue
+			}
+			a.Humidity[index(u, v)] = col[v]*(1-spread) + (col[l]+col[r])*spread/2
+		}
+	}
+	return a
+}
@@ -0,0 +1,5 @@
+module github.com/uxff/topograph-maker
+
+go 1.13
+
+require gopkg.in/yaml.v2 v2.4.0
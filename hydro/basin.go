@@ -0,0 +1,179 @@
+package hydro
+
+import (
+	"sort"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 一个流域 所有流向同一个河口或内陆湖的点
+type Basin struct {
+	ID          int
+	Outlet      int     // 河口点 入海前最后一个陆地点 内流湖时为汇入量最大的湖岸点 不溢出的洼地为其中汇流量最大的点
+	Endorheic   bool    // 内流区 流入不与外海相连的湖或不溢出的洼地
+	Area        int     // 点数 内流湖的湖面也计入
+	MainStemLen float32 // 主干长度 从河口沿汇流量最大的上游走到源头
+}
+
+// 流域划分结果
+type BasinMap struct {
+	Width  int
+	Height int
+	Labels []int32 // 每个点的流域ID -1表示外海
+	List   []*Basin
+}
+
+// 按主流向划分流域 外海的河口各自成一个流域 流入同一个内陆湖的点合为一个内流区
+// lakes不为nil时 流入不溢出的洼地(湖水蒸发完 没有出湖河流)的点也合为一个内流区
+func NewBasinMap(f *FlowField, m *terrain.Topomap, lakes *LakeMap) *BasinMap {
+	b := &BasinMap{Width: f.Width, Height: f.Height, Labels: make([]int32, len(f.Dir))}
+	for idx := range b.Labels {
+		b.Labels[idx] = -1
+	}
+	lakeOf, inland := seaRegions(m)
+	lakeBasin := make(map[int]*Basin)
+	sinkBasin := make(map[int32]*Basin)
+
+	// 拓扑逆序 下游点先于上游点被标记
+	for i := len(f.order) - 1; i >= 0; i-- {
+		idx := f.order[i]
+		if m.IsSea(idx) {
+			continue
+		}
+		di := f.Downstream(idx)
+		if lakes != nil && lakes.sinkOf[idx] >= 0 {
+			// 洼地中的点先于流入它的点被标记
+			sink := sinkBasin[lakes.sinkOf[idx]]
+			if sink == nil {
+				sink = b.newBasin(idx)
+				sink.Endorheic = true
+				sinkBasin[lakes.sinkOf[idx]] = sink
+			} else if f.Acc[idx] > f.Acc[sink.Outlet] {
+				sink.Outlet = idx
+			}
+			b.Labels[idx] = int32(sink.ID)
+			continue
+		}
+		switch {
+		case di >= 0 && !m.IsSea(di):
+			b.Labels[idx] = b.Labels[di]
+			continue
+		case di >= 0 && inland[lakeOf[di]]:
+			lake := lakeBasin[lakeOf[di]]
+			if lake == nil {
+				lake = b.newBasin(idx)
+				lake.Endorheic = true
+				lakeBasin[lakeOf[di]] = lake
+			} else if f.Acc[idx] > f.Acc[lake.Outlet] {
+				lake.Outlet = idx
+			}
+			b.Labels[idx] = int32(lake.ID)
+		default:
+			b.Labels[idx] = int32(b.newBasin(idx).ID)
+		}
+	}
+	// 内陆湖面并入流入它的流域
+	for idx, li := range lakeOf {
+		if li >= 0 && lakeBasin[li] != nil {
+			b.Labels[idx] = int32(lakeBasin[li].ID)
+		}
+	}
+
+	for _, l := range b.Labels {
+		if l >= 0 {
+			b.List[l].Area++
+		}
+	}
+	for _, basin := range b.List {
+		basin.MainStemLen = mainStemLen(f, basin.Outlet)
+	}
+	return b
+}
+
+func (b *BasinMap) newBasin(outlet int) *Basin {
+	basin := &Basin{ID: len(b.List), Outlet: outlet}
+	b.List = append(b.List, basin)
+	return basin
+}
+
+// (x,y)所在的流域 外海返回nil
+func (b *BasinMap) At(x, y int) *Basin {
+	if x < 0 || y < 0 || x >= b.Width || y >= b.Height {
+		return nil
+	}
+	if l := b.Labels[x+y*b.Width]; l >= 0 {
+		return b.List[l]
+	}
+	return nil
+}
+
+// 面积最大的n个流域
+func (b *BasinMap) Largest(n int) []*Basin {
+	list := append([]*Basin(nil), b.List...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Area > list[j].Area })
+	if n < len(list) {
+		list = list[:n]
+	}
+	return list
+}
+
+// 从河口沿汇流量最大的上游点走到源头的长度
+func mainStemLen(f *FlowField, outlet int) float32 {
+	var length float32
+	for idx := outlet; ; {
+		x, y := idx%f.Width, idx/f.Width
+		next, nextD := -1, 0
+		for d, off := range d8Offsets {
+			nx, ny := x+off[0], y+off[1]
+			if nx < 0 || ny < 0 || nx >= f.Width || ny >= f.Height {
+				continue
+			}
+			ni := nx + ny*f.Width
+			if f.Downstream(ni) == idx && (next < 0 || f.Acc[ni] > f.Acc[next]) {
+				next, nextD = ni, d
+			}
+		}
+		if next < 0 {
+			return length
+		}
+		length += d8Dist[nextD]
+		idx = next
+	}
+}
+
+// 海的8连通区域编号 inland表示该区域不接触地图边缘 是内陆湖
+func seaRegions(m *terrain.Topomap) (regionOf []int, inland []bool) {
+	regionOf = make([]int, len(m.Data))
+	for idx := range regionOf {
+		regionOf[idx] = -1
+	}
+	for start := range m.Data {
+		if regionOf[start] >= 0 || !m.IsSea(start) {
+			continue
+		}
+		ri := len(inland)
+		regionOf[start] = ri
+		isInland := true
+		queue := []int{start}
+		for qi := 0; qi < len(queue); qi++ {
+			idx := queue[qi]
+			x, y := idx%m.Width, idx/m.Width
+			if x == 0 || y == 0 || x == m.Width-1 || y == m.Height-1 {
+				isInland = false
+			}
+			for _, off := range d8Offsets {
+				nx, ny := x+off[0], y+off[1]
+				if nx < 0 || ny < 0 || nx >= m.Width || ny >= m.Height {
+					continue
+				}
+				ni := nx + ny*m.Width
+				if regionOf[ni] < 0 && m.IsSea(ni) {
+					regionOf[ni] = ri
+					queue = append(queue, ni)
+				}
+			}
+		}
+		inland = append(inland, isInland)
+	}
+	return regionOf, inland
+}
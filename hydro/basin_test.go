@@ -0,0 +1,39 @@
+package hydro
+
+import "testing"
+
+// 碗里的湖不满时整个碗是一个内流区 溢出后归入缺口的流域
+func TestBasinEndorheicBowl(t *testing.T) {
+	m := bowlMap()
+	f := NewFlowField(m, nil, Routing{})
+	for _, c := range []struct {
+		rain      float32
+		endorheic bool
+	}{{1, true}, {2, false}} {
+		b := NewBasinMap(f, m, NewLakeMap(f, m, LakeConfig{RainVolume: c.rain}))
+		bowl := b.At(3, 3)
+		if bowl == nil || bowl.Endorheic != c.endorheic {
+			t.Fatalf("rain %v: bowl basin %+v, want endorheic %v", c.rain, bowl, c.endorheic)
+		}
+		area := 0
+		for y := 1; y < m.Height-1; y++ {
+			for x := 1; x < m.Width-1; x++ {
+				if b.At(x, y) != bowl {
+					t.Errorf("rain %v: (%d,%d) not in the bowl basin", c.rain, x, y)
+				}
+				area++
+			}
+		}
+		if !c.endorheic {
+			area++ // 缺口是河口
+		}
+		if bowl.Area != area {
+			t.Errorf("rain %v: bowl area %d, want %d", c.rain, bowl.Area, area)
+		}
+	}
+
+	// 不给湖泊时只按流向划分 碗流出缺口
+	if b := NewBasinMap(f, m, nil); b.At(3, 3).Endorheic {
+		t.Errorf("bowl endorheic without lakes")
+	}
+}
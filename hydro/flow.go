@@ -0,0 +1,333 @@
+package hydro
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// D8的8个方向 下标即方向编码 与getNeighbors的顺序无关
+var d8Offsets = [8][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+
+// 对角方向的距离
+var d8Dist = [8]float32{1, 1.4142135, 1, 1.4142135, 1, 1.4142135, 1, 1.4142135}
+
+// 没有下游的点 地图边缘或海
+const FlowOutlet int8 = -1
+
+// 汇流算法
+type RoutingMode int
+
+const (
+	RoutingD8   RoutingMode = iota // 全部流向最陡的一个邻居 默认
+	RoutingDinf                    // D-infinity 在最陡的三角面上按角度分给两个邻居
+	RoutingMFD                     // 多流向FD8 按坡度的Exponent次方分给所有更低的邻居
+)
+
+// MFD默认的坡度指数 越大越接近D8
+const DefaultMFDExponent = 1.1
+
+func ParseRoutingMode(name string) (RoutingMode, error) {
+	switch name {
+	case "", "d8":
+		return RoutingD8, nil
+	case "dinf", "d-inf", "dinfinity":
+		return RoutingDinf, nil
+	case "mfd", "fd8":
+		return RoutingMFD, nil
+	}
+	return RoutingD8, fmt.Errorf("unknown flow routing: %s", name)
+}
+
+// 汇流参数
+type Routing struct {
+	Mode     RoutingMode
+	Exponent float64 // MFD的坡度指数 0表示DefaultMFDExponent
+}
+
+// 确定性的汇流计算结果 不依赖随机数和水滴
+type FlowField struct {
+	Width  int
+	Height int
+	Filled []float32 // 填洼后的高度 大于原高度的部分是湖
+	Dir    []int8    // 主流向 分流最多的d8Offsets下标 FlowOutlet表示出口
+	Frac   []float32 // 分流比例 每点8个 按d8Offsets顺序 D8时为nil 只用Dir
+	Acc    []float32 // 汇流累积量 包括本点 单位为点数*降水
+	order  []int     // 从上游到下游的拓扑顺序
+}
+
+// 优先队列的元素 高度相同时按下标出队 保证结果可复现
+type floodCell struct {
+	idx int
+	h   float32
+}
+
+type floodQueue []floodCell
+
+func (q floodQueue) Len() int { return len(q) }
+func (q floodQueue) Less(i, j int) bool {
+	if q[i].h != q[j].h {
+		return q[i].h < q[j].h
+	}
+	return q[i].idx < q[j].idx
+}
+func (q floodQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *floodQueue) Push(x interface{}) { *q = append(*q, x.(floodCell)) }
+func (q *floodQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// 计算m的汇流 rain为每点的降水权重 为nil时每点为1
+func NewFlowField(m *terrain.Topomap, rain []float32, route Routing) *FlowField {
+	f := &FlowField{
+		Width:  m.Width,
+		Height: m.Height,
+		Filled: make([]float32, len(m.Data)),
+		Dir:    make([]int8, len(m.Data)),
+		Acc:    make([]float32, len(m.Data)),
+	}
+	parent := f.fill(m)
+	f.assignD8(parent)
+	switch route.Mode {
+	case RoutingDinf:
+		f.assignDinf(parent)
+	case RoutingMFD:
+		exp := route.Exponent
+		if exp <= 0 {
+			exp = DefaultMFDExponent
+		}
+		f.assignMFD(parent, exp)
+	}
+	f.accumulate(rain)
+	return f
+}
+
+// priority-flood填洼 从地图边缘和海开始 由低到高淹没 洼地被填平到溢出口的高度
+// 返回每点被淹没时来自的邻居方向 用于给平地指定流向
+func (f *FlowField) fill(m *terrain.Topomap) []int8 {
+	w, h := f.Width, f.Height
+	parent := make([]int8, len(m.Data))
+	done := make([]bool, len(m.Data))
+	q := make(floodQueue, 0, 2*(w+h))
+	for idx := range m.Data {
+		parent[idx] = FlowOutlet
+		x, y := idx%w, idx/w
+		if x == 0 || y == 0 || x == w-1 || y == h-1 || m.IsSea(idx) {
+			f.Filled[idx] = m.Data[idx]
+			done[idx] = true
+			q = append(q, floodCell{idx, m.Data[idx]})
+		}
+	}
+	heap.Init(&q)
+
+	for q.Len() > 0 {
+		c := heap.Pop(&q).(floodCell)
+		cx, cy := c.idx%w, c.idx/w
+		for d, off := range d8Offsets {
+			nx, ny := cx+off[0], cy+off[1]
+			if nx < 0 || ny < 0 || nx >= w || ny >= h {
+				continue
+			}
+			ni := nx + ny*w
+			if done[ni] {
+				continue
+			}
+			done[ni] = true
+			// 邻居指回当前点的方向
+			parent[ni] = int8((d + 4) % 8)
+			nh := m.Data[ni]
+			if nh < c.h {
+				nh = c.h
+			}
+			f.Filled[ni] = nh
+			heap.Push(&q, floodCell{ni, nh})
+		}
+	}
+	return parent
+}
+
+// 在填洼后的高度上取最陡的下降方向 没有更低的邻居(平地和湖面)时沿淹没来源流出
+func (f *FlowField) assignD8(parent []int8) {
+	w, h := f.Width, f.Height
+	for idx := range f.Dir {
+		f.Dir[idx] = parent[idx]
+		if parent[idx] == FlowOutlet {
+			continue
+		}
+		x, y := idx%w, idx/w
+		var best float32
+		for d, off := range d8Offsets {
+			nx, ny := x+off[0], y+off[1]
+			if nx < 0 || ny < 0 || nx >= w || ny >= h {
+				continue
+			}
+			if slope := (f.Filled[idx] - f.Filled[nx+ny*w]) / d8Dist[d]; slope > best {
+				best = slope
+				f.Dir[idx] = int8(d)
+			}
+		}
+	}
+}
+
+// D-infinity的8个三角面 每个面由一个正方向和相邻的对角方向组成
+var dinfFacets = [8][2]int{{0, 1}, {2, 1}, {2, 3}, {4, 3}, {4, 5}, {6, 5}, {6, 7}, {0, 7}}
+
+// D-infinity 在下降最陡的三角面上 按流向角度把流量分给面上的两个邻居
+func (f *FlowField) assignDinf(parent []int8) {
+	f.Frac = make([]float32, 8*len(f.Dir))
+	for idx := range f.Dir {
+		if parent[idx] == FlowOutlet {
+			continue
+		}
+		e0 := float64(f.Filled[idx])
+		bestS, bestR, bestFacet := 0.0, 0.0, -1
+		for fi, facet := range dinfFacets {
+			e1, ok1 := f.neighborFilled(idx, facet[0])
+			e2, ok2 := f.neighborFilled(idx, facet[1])
+			if !ok1 || !ok2 {
+				continue
+			}
+			s1, s2 := e0-e1, e1-e2
+			r, s := math.Atan2(s2, s1), math.Hypot(s1, s2)
+			if r < 0 {
+				r, s = 0, s1
+			} else if r > math.Pi/4 {
+				r, s = math.Pi/4, (e0-e2)/math.Sqrt2
+			}
+			if s > bestS {
+				bestS, bestR, bestFacet = s, r, fi
+			}
+		}
+		fr := f.Frac[8*idx : 8*idx+8]
+		if bestFacet < 0 {
+			// 平地和边缘点沿D8流向
+			fr[f.Dir[idx]] = 1
+			continue
+		}
+		diag := float32(bestR / (math.Pi / 4))
+		facet := dinfFacets[bestFacet]
+		fr[facet[0]], fr[facet[1]] = 1-diag, diag
+		f.Dir[idx] = int8(facet[0])
+		if diag > 0.5 {
+			f.Dir[idx] = int8(facet[1])
+		}
+	}
+}
+
+// MFD 按坡度的exp次方分给所有更低的邻居
+func (f *FlowField) assignMFD(parent []int8, exp float64) {
+	f.Frac = make([]float32, 8*len(f.Dir))
+	for idx := range f.Dir {
+		if parent[idx] == FlowOutlet {
+			continue
+		}
+		fr := f.Frac[8*idx : 8*idx+8]
+		var sum float64
+		for d := range d8Offsets {
+			nh, ok := f.neighborFilled(idx, d)
+			if !ok {
+				continue
+			}
+			if slope := (float64(f.Filled[idx]) - nh) / float64(d8Dist[d]); slope > 0 {
+				w := math.Pow(slope, exp)
+				fr[d] = float32(w)
+				sum += w
+			}
+		}
+		if sum == 0 {
+			fr[f.Dir[idx]] = 1
+			continue
+		}
+		for d := range fr {
+			fr[d] = float32(float64(fr[d]) / sum)
+		}
+	}
+}
+
+// idx在方向d上的邻居填洼高度 超出地图返回false
+func (f *FlowField) neighborFilled(idx, d int) (float64, bool) {
+	nx, ny := idx%f.Width+d8Offsets[d][0], idx/f.Width+d8Offsets[d][1]
+	if nx < 0 || ny < 0 || nx >= f.Width || ny >= f.Height {
+		return 0, false
+	}
+	return float64(f.Filled[nx+ny*f.Width]), true
+}
+
+// 下游点的下标 出口返回-1
+func (f *FlowField) Downstream(idx int) int {
+	d := f.Dir[idx]
+	if d == FlowOutlet {
+		return -1
+	}
+	return idx + d8Offsets[d][0] + d8Offsets[d][1]*f.Width
+}
+
+// 遍历idx的所有下游点和分流比例
+func (f *FlowField) eachReceiver(idx int, fn func(ni int, frac float32)) {
+	if f.Frac == nil {
+		if di := f.Downstream(idx); di >= 0 {
+			fn(di, 1)
+		}
+		return
+	}
+	for d, fr := range f.Frac[8*idx : 8*idx+8] {
+		if fr > 0 {
+			fn(idx+d8Offsets[d][0]+d8Offsets[d][1]*f.Width, fr)
+		}
+	}
+}
+
+// 按拓扑顺序从上游向下游累加流量 下游点都比上游低或是淹没来源 不会成环
+func (f *FlowField) accumulate(rain []float32) {
+	inDeg := make([]int32, len(f.Dir))
+	for idx := range f.Dir {
+		if rain != nil {
+			f.Acc[idx] = rain[idx]
+		} else {
+			f.Acc[idx] = 1
+		}
+		f.eachReceiver(idx, func(ni int, _ float32) { inDeg[ni]++ })
+	}
+	f.order = make([]int, 0, len(f.Dir))
+	for idx, n := range inDeg {
+		if n == 0 {
+			f.order = append(f.order, idx)
+		}
+	}
+	for i := 0; i < len(f.order); i++ {
+		idx := f.order[i]
+		f.eachReceiver(idx, func(ni int, frac float32) {
+			f.Acc[ni] += f.Acc[idx] * frac
+			if inDeg[ni]--; inDeg[ni] == 0 {
+				f.order = append(f.order, ni)
+			}
+		})
+	}
+}
+
+// 湖水深度 填洼高度与原高度之差
+func (f *FlowField) Depth(m *terrain.Topomap, idx int) float32 {
+	return f.Filled[idx] - m.Data[idx]
+}
+
+// 把汇流结果写入WaterMap 流量写入Q 流向写入场向量 填洼深度写入积水H
+func (w *WaterMap) ApplyFlow(f *FlowField) {
+	for idx := range w.Data {
+		dot := &w.Data[idx]
+		dot.Q = int(f.Acc[idx])
+		dot.H = int(f.Depth(w.topo, idx))
+		// 场向量为各下游方向按分流比例的合成
+		dot.XPower, dot.YPower = 0, 0
+		f.eachReceiver(idx, func(ni int, frac float32) {
+			dx, dy := float32(ni%w.Width-idx%w.Width), float32(ni/w.Width-idx/w.Width)
+			dist := float32(math.Hypot(float64(dx), float64(dy)))
+			dot.XPower += frac * dx / dist
+			dot.YPower += frac * dy / dist
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package hydro
+
+import (
+	"testing"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 7x7的碗 边框高5 只在(3,0)有高2的缺口 碗底高1 中心(3,3)高0
+func bowlMap() *terrain.Topomap {
+	const w, h = 7, 7
+	m := terrain.NewTopomap(w, h)
+	for idx := range m.Data {
+		x, y := idx%w, idx/w
+		switch {
+		case x == 3 && y == 0:
+			m.Data[idx] = 2
+		case x == 0 || y == 0 || x == w-1 || y == h-1:
+			m.Data[idx] = 5
+		case x == 3 && y == 3:
+			m.Data[idx] = 0
+		default:
+			m.Data[idx] = 1
+		}
+	}
+	return m
+}
+
+func TestFlowFieldFillBowl(t *testing.T) {
+	m := bowlMap()
+	f := NewFlowField(m, nil, Routing{})
+	outlet := 3
+	for idx := range m.Data {
+		x, y := idx%m.Width, idx/m.Width
+		if x == 0 || y == 0 || x == m.Width-1 || y == m.Height-1 {
+			if f.Filled[idx] != m.Data[idx] || f.Dir[idx] != FlowOutlet {
+				t.Fatalf("border (%d,%d) filled %f dir %d", x, y, f.Filled[idx], f.Dir[idx])
+			}
+			continue
+		}
+		// 碗底填到缺口的高度 每个点都沿流向走到缺口
+		if f.Filled[idx] != 2 {
+			t.Errorf("(%d,%d) filled %f, want 2", x, y, f.Filled[idx])
+		}
+		ci, steps := idx, 0
+		for ; f.Downstream(ci) >= 0 && steps < len(m.Data); steps++ {
+			ci = f.Downstream(ci)
+		}
+		if ci != outlet {
+			t.Errorf("(%d,%d) drains to %d, want %d", x, y, ci, outlet)
+		}
+	}
+	if f.Acc[outlet] != 26 {
+		t.Errorf("outlet acc %f, want 26", f.Acc[outlet])
+	}
+}
+
+// 各种汇流算法都守恒 流出地图的总量等于降水总量
+func TestFlowFieldConserve(t *testing.T) {
+	m, _, _ := buildSeededMap(t, 42)
+	for _, mode := range []RoutingMode{RoutingD8, RoutingDinf, RoutingMFD} {
+		f := NewFlowField(m, nil, Routing{Mode: mode})
+		if len(f.order) != len(m.Data) {
+			t.Fatalf("mode %d: order has %d cells, want %d", mode, len(f.order), len(m.Data))
+		}
+		var out float64
+		for idx := range f.Dir {
+			received := false
+			f.eachReceiver(idx, func(int, float32) { received = true })
+			if !received {
+				out += float64(f.Acc[idx])
+			}
+		}
+		if d := out - float64(len(m.Data)); d > 1 || d < -1 {
+			t.Errorf("mode %d: outflow %f, want %d", mode, out, len(m.Data))
+		}
+	}
+}
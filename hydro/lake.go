@@ -0,0 +1,328 @@
+package hydro
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 湖泊的水量参数
+type LakeConfig struct {
+	RainVolume  float32 // 集水区每个点汇入的水量 高度单位*点
+	Evaporation float32 // 湖面每个点蒸发的水量
+}
+
+// 一个洼地中的湖
+type Lake struct {
+	ID        int
+	Cells     []int      // 湖面覆盖的点
+	Level     float32    // 水面高度
+	Spill     float32    // 溢出高度 洼地边缘最低处
+	Outlet    int        // 溢出后流向的湖外点
+	Full      bool       // 水位达到溢出高度 有出湖河流
+	Inflow    float32    // 汇入的水量
+	Volume    float32    // 蓄水量
+	Capacity  float32    // 到溢出高度为止的容积
+	MaxDepth  float32    // 最大水深
+	MeanDepth float32    // 平均水深
+	Polygons  []LakeRing // 湖岸轮廓 外轮廓在前 按面积从大到小
+}
+
+// 一条闭合的湖岸线 只在角上相接的湖面分成不同的外轮廓
+type LakeRing struct {
+	Points []Point
+	Hole   bool // 湖中岛的岸线 否则为湖面的外轮廓
+}
+
+// 所有湖泊
+type LakeMap struct {
+	Width  int
+	Height int
+	Lakes  []*Lake
+	Depth  []float32 // 每个点的水深 不在湖中为0
+	lakeOf []int32
+	sinkOf []int32 // 不溢出的洼地(湖不满或水量不足以成湖)中的点为洼地编号 否则为-1
+}
+
+// 由填洼结果找出每个洼地 按汇入水量从最低点开始灌水 得到水平的湖面
+// 水量不足时湖面低于溢出高度 没有出湖河流 上游不溢出的湖的集水区仍计入下游
+func NewLakeMap(f *FlowField, m *terrain.Topomap, c LakeConfig) *LakeMap {
+	lm := &LakeMap{Width: f.Width, Height: f.Height, Depth: make([]float32, len(f.Dir)), lakeOf: make([]int32, len(f.Dir)), sinkOf: make([]int32, len(f.Dir))}
+	for idx := range lm.lakeOf {
+		lm.lakeOf[idx], lm.sinkOf[idx] = -1, -1
+	}
+	depression := make([]int32, len(f.Dir))
+	for idx := range depression {
+		depression[idx] = -1
+	}
+
+	for start := range f.Filled {
+		if depression[start] >= 0 || f.Filled[start] <= m.Data[start] {
+			continue
+		}
+		// 溢出高度相同且相连的洼地点
+		di := int32(start)
+		depression[start] = di
+		cells := []int{start}
+		for qi := 0; qi < len(cells); qi++ {
+			lm.eachNeighbor(cells[qi], func(ni int) {
+				if depression[ni] < 0 && f.Filled[ni] > m.Data[ni] && f.Filled[ni] == f.Filled[start] {
+					depression[ni] = di
+					cells = append(cells, ni)
+				}
+			})
+		}
+
+		lake := &Lake{Spill: f.Filled[start], Outlet: -1}
+		var exitAcc, maxAcc float32
+		for _, idx := range cells {
+			lake.Capacity += lake.Spill - m.Data[idx]
+			// 流出洼地的点 汇流量合起来是整个集水区 流出最多的作为出口
+			if out := f.Downstream(idx); out >= 0 && depression[out] != di {
+				exitAcc += f.Acc[idx]
+				if f.Acc[idx] > maxAcc {
+					maxAcc, lake.Outlet = f.Acc[idx], out
+				}
+			}
+		}
+		lake.Inflow = exitAcc * c.RainVolume
+		if lm.flood(m, lake, cells, depression, di, c.Evaporation) {
+			lake.ID = len(lm.Lakes)
+			lm.Lakes = append(lm.Lakes, lake)
+			for _, idx := range lake.Cells {
+				lm.lakeOf[idx] = int32(lake.ID)
+			}
+			lake.Polygons = lm.trace(lake.Cells)
+		}
+		// 水在洼地中蒸发完 不流出
+		if !lake.Full {
+			for _, idx := range cells {
+				lm.sinkOf[idx] = di
+			}
+		}
+	}
+	return lm
+}
+
+// 从洼地最低点开始按高度由低到高淹没 水量用完或到达溢出高度为止 没有形成湖返回false
+func (lm *LakeMap) flood(m *terrain.Topomap, lake *Lake, cells []int, depression []int32, di int32, evap float32) bool {
+	bottom := cells[0]
+	for _, idx := range cells {
+		if m.Data[idx] < m.Data[bottom] {
+			bottom = idx
+		}
+	}
+	queued := make(map[int]bool, len(cells))
+	q := floodQueue{{bottom, m.Data[bottom]}}
+	queued[bottom] = true
+	level, area := m.Data[bottom], float32(0)
+	remain := lake.Inflow
+
+	for {
+		next := lake.Spill
+		if q.Len() > 0 && q[0].h > level {
+			next = q[0].h
+		} else if q.Len() > 0 {
+			next = level
+		}
+		// 水位升到next需要的水量
+		need := area * (next - level)
+		if area > 0 && remain < need {
+			level, remain = level+remain/area, 0
+			break
+		}
+		level, remain = next, remain-need
+		if q.Len() == 0 {
+			lake.Full = true
+			break
+		}
+		// 新淹没的点增加蒸发 越过低处边缘后遇到的更低的点要先灌满到水面
+		cost := evap + level - q[0].h
+		if remain < cost {
+			break
+		}
+		c := heap.Pop(&q).(floodCell)
+		remain -= cost
+		lake.Cells = append(lake.Cells, c.idx)
+		area++
+		lm.eachNeighbor(c.idx, func(ni int) {
+			if depression[ni] == di && !queued[ni] {
+				queued[ni] = true
+				heap.Push(&q, floodCell{ni, m.Data[ni]})
+			}
+		})
+	}
+	if len(lake.Cells) == 0 {
+		return false
+	}
+
+	lake.Level = level
+	for _, idx := range lake.Cells {
+		d := level - m.Data[idx]
+		if d < 0 {
+			d = 0
+		}
+		lm.Depth[idx] = d
+		if d > lake.MaxDepth {
+			lake.MaxDepth = d
+		}
+	}
+	// 蓄水量为实际留在湖中的水 不超过汇入量 溢出的部分流走
+	lake.Volume = lake.Inflow - remain - evap*area
+	if lake.Volume < 0 {
+		lake.Volume = 0
+	}
+	lake.MeanDepth = lake.Volume / float32(len(lake.Cells))
+	if !lake.Full {
+		lake.Outlet = -1
+	}
+	return true
+}
+
+func (lm *LakeMap) eachNeighbor(idx int, fn func(ni int)) {
+	x, y := idx%lm.Width, idx/lm.Width
+	for _, off := range d8Offsets {
+		nx, ny := x+off[0], y+off[1]
+		if nx >= 0 && ny >= 0 && nx < lm.Width && ny < lm.Height {
+			fn(nx + ny*lm.Width)
+		}
+	}
+}
+
+// 沿湖面点的外边缘连成闭合的轮廓 顶点在点的角上 共线的顶点被省略
+// 外轮廓顺时针 岛的岸线逆时针 两个湖面点只在角上相接时各自成环
+func (lm *LakeMap) trace(cells []int) []LakeRing {
+	in := make(map[int]bool, len(cells))
+	for _, idx := range cells {
+		in[idx] = true
+	}
+	has := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < lm.Width && y < lm.Height && in[x+y*lm.Width]
+	}
+
+	// 顺时针的边 起点->终点 按起点索引
+	type edge struct{ x0, y0, x1, y1 int }
+	var edges []edge
+	from := make(map[[2]int][]int)
+	addEdge := func(e edge) {
+		from[[2]int{e.x0, e.y0}] = append(from[[2]int{e.x0, e.y0}], len(edges))
+		edges = append(edges, e)
+	}
+	for _, idx := range cells {
+		x, y := idx%lm.Width, idx/lm.Width
+		if !has(x, y-1) {
+			addEdge(edge{x, y, x + 1, y})
+		}
+		if !has(x+1, y) {
+			addEdge(edge{x + 1, y, x + 1, y + 1})
+		}
+		if !has(x, y+1) {
+			addEdge(edge{x + 1, y + 1, x, y + 1})
+		}
+		if !has(x-1, y) {
+			addEdge(edge{x, y + 1, x, y})
+		}
+	}
+
+	used := make([]bool, len(edges))
+	var polygons []LakeRing
+	for ei := range edges {
+		if used[ei] {
+			continue
+		}
+		var ring []Point
+		for cur := ei; cur >= 0; {
+			used[cur] = true
+			e := edges[cur]
+			ring = append(ring, Point{float32(e.x0), float32(e.y0)})
+			// 角上有两条出边时优先右转 贴着当前的湖面点走
+			dx, dy := e.x1-e.x0, e.y1-e.y0
+			cur = -1
+			for _, ni := range from[[2]int{e.x1, e.y1}] {
+				if used[ni] {
+					continue
+				}
+				if n := edges[ni]; cur < 0 || (n.x1-n.x0 == -dy && n.y1-n.y0 == dx) {
+					cur = ni
+				}
+			}
+		}
+		ring = simplifyRing(ring)
+		polygons = append(polygons, LakeRing{Points: ring, Hole: signedArea(ring) < 0})
+	}
+	sort.SliceStable(polygons, func(a, b int) bool {
+		if polygons[a].Hole != polygons[b].Hole {
+			return !polygons[a].Hole
+		}
+		return ringArea(polygons[a].Points) > ringArea(polygons[b].Points)
+	})
+	return polygons
+}
+
+// 去掉共线的中间顶点
+func simplifyRing(ring []Point) []Point {
+	n := len(ring)
+	if n < 4 {
+		return ring
+	}
+	var out []Point
+	for i, p := range ring {
+		prev, next := ring[(i+n-1)%n], ring[(i+1)%n]
+		if (prev.X == p.X && p.X == next.X) || (prev.Y == p.Y && p.Y == next.Y) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// 多边形面积的绝对值
+func ringArea(ring []Point) float32 {
+	a := signedArea(ring)
+	if a < 0 {
+		a = -a
+	}
+	return a
+}
+
+// 带方向的面积 y轴向下时顺时针为正
+func signedArea(ring []Point) float32 {
+	var a float32
+	for i, p := range ring {
+		q := ring[(i+1)%len(ring)]
+		a += p.X*q.Y - q.X*p.Y
+	}
+	return a / 2
+}
+
+// (x,y)所在的湖 不在湖中返回nil
+func (lm *LakeMap) At(x, y int) *Lake {
+	if x < 0 || y < 0 || x >= lm.Width || y >= lm.Height {
+		return nil
+	}
+	if li := lm.lakeOf[x+y*lm.Width]; li >= 0 {
+		return lm.Lakes[li]
+	}
+	return nil
+}
+
+// 湖溢出后沿流向遇到的第一条河段 不溢出或没有河段返回nil
+func (l *Lake) OutflowRiver(f *FlowField, n *RiverNetwork) *RiverSegment {
+	if !l.Full {
+		return nil
+	}
+	for idx := l.Outlet; idx >= 0; idx = f.Downstream(idx) {
+		if si := n.segOf[idx]; si >= 0 {
+			return n.Segments[si]
+		}
+	}
+	return nil
+}
+
+// 把湖水深度写入积水H 替代填洼的深度
+func (w *WaterMap) ApplyLakes(lm *LakeMap) {
+	for idx := range w.Data {
+		w.Data[idx].H = int(lm.Depth[idx] + 0.5)
+	}
+}
@@ -0,0 +1,62 @@
+package hydro
+
+import "testing"
+
+func TestLakeFillsBowl(t *testing.T) {
+	m := bowlMap()
+	f := NewFlowField(m, nil, Routing{})
+
+	// 缺口内侧的点汇入25 碗的容积为26 水量不够时不溢出
+	lm := NewLakeMap(f, m, LakeConfig{RainVolume: 1})
+	if len(lm.Lakes) != 1 {
+		t.Fatalf("lake num %d, want 1", len(lm.Lakes))
+	}
+	l := lm.Lakes[0]
+	if l.Full || l.Outlet != -1 || l.Capacity != 26 || l.Inflow != 25 {
+		t.Errorf("dry lake: full %v outlet %d capacity %f inflow %f", l.Full, l.Outlet, l.Capacity, l.Inflow)
+	}
+	if l.Volume > l.Inflow || l.Level >= l.Spill {
+		t.Errorf("dry lake: volume %f inflow %f level %f spill %f", l.Volume, l.Inflow, l.Level, l.Spill)
+	}
+
+	lm = NewLakeMap(f, m, LakeConfig{RainVolume: 2})
+	l = lm.Lakes[0]
+	if !l.Full || l.Outlet != 3 || l.Level != 2 || l.Volume != 26 || l.MaxDepth != 2 || len(l.Cells) != 25 {
+		t.Errorf("full lake: full %v outlet %d level %f volume %f max depth %f cells %d",
+			l.Full, l.Outlet, l.Level, l.Volume, l.MaxDepth, len(l.Cells))
+	}
+	if lm.At(3, 3) != l || lm.At(0, 0) != nil {
+		t.Errorf("lake lookup wrong")
+	}
+	if len(l.Polygons) != 1 || l.Polygons[0].Hole || ringArea(l.Polygons[0].Points) != 25 {
+		t.Errorf("full lake polygons: %+v", l.Polygons)
+	}
+}
+
+func TestLakeTraceRings(t *testing.T) {
+	lm := &LakeMap{Width: 6, Height: 6}
+	at := func(x, y int) int { return x + y*6 }
+	// 3x3的环 中间是岛 另有一个只在角上相接的点
+	var cells []int
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			if x != 1 || y != 1 {
+				cells = append(cells, at(x, y))
+			}
+		}
+	}
+	cells = append(cells, at(3, 3))
+	rings := lm.trace(cells)
+	want := []struct {
+		area float32
+		hole bool
+	}{{9, false}, {1, false}, {1, true}}
+	if len(rings) != len(want) {
+		t.Fatalf("ring num %d, want %d: %+v", len(rings), len(want), rings)
+	}
+	for i, w := range want {
+		if a := ringArea(rings[i].Points); a != w.area || rings[i].Hole != w.hole {
+			t.Errorf("ring %d: area %f hole %v, want %f %v", i, a, rings[i].Hole, w.area, w.hole)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package hydro
+
+import (
+	"math"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 虚拟管道浅水模型参数 每个点与上下左右4个邻居之间有一根管道
+// 管道流量由两端水面高度差驱动 地图边缘封闭 除降水和蒸发外水量守恒
+type PipeConfig struct {
+	Dt          float32 // 时间步长 默认0.05
+	Gravity     float32 // 重力 默认9.8
+	PipeArea    float32 // 管道截面积 默认1
+	Rain        float32 // 每单位时间每点的降水 默认0.01
+	Evaporation float32 // 每单位时间的蒸发比例 默认0.01
+	Capacity    float32 // 携沙能力系数 0表示不搬运泥沙
+	Dissolve    float32 // 溶解(侵蚀)速率 默认0.01
+	Deposit     float32 // 沉积速率 默认0.01
+	MinTilt     float32 // 计算携沙能力的最小坡度正弦 默认0.05 避免平地完全不侵蚀
+}
+
+func (c *PipeConfig) setDefaults() {
+	defaults := []struct {
+		v   *float32
+		def float32
+	}{
+		{&c.Dt, 0.05}, {&c.Gravity, 9.8}, {&c.PipeArea, 1}, {&c.Rain, 0.01},
+		{&c.Evaporation, 0.01}, {&c.Dissolve, 0.01}, {&c.Deposit, 0.01}, {&c.MinTilt, 0.05},
+	}
+	for _, d := range defaults {
+		if *d.v <= 0 {
+			*d.v = d.def
+		}
+	}
+}
+
+// 管道方向
+const (
+	pipeLeft = iota
+	pipeRight
+	pipeTop
+	pipeBottom
+)
+
+// 浅水模拟的状态 每一步分几个阶段 每个阶段按行带并行 只写本点 读上一阶段的结果
+type PipeSim struct {
+	Width    int
+	Height   int
+	Water    []float32 // 水深
+	Sediment []float32 // 悬浮泥沙
+	VelX     []float32 // 速度场
+	VelY     []float32
+	RainMap  []float32 // 每点的降水倍数 nil表示均匀降水
+
+	conf   PipeConfig
+	topo   *terrain.Topomap
+	flux   [4][]float32 // 流向4个邻居的流量
+	water2 []float32
+	sed2   []float32
+	ground []float32
+}
+
+func NewPipeSim(m *terrain.Topomap, c PipeConfig) *PipeSim {
+	c.setDefaults()
+	n := len(m.Data)
+	s := &PipeSim{
+		Width: m.Width, Height: m.Height,
+		Water: make([]float32, n), Sediment: make([]float32, n),
+		VelX: make([]float32, n), VelY: make([]float32, n),
+		conf: c, topo: m,
+		water2: make([]float32, n), sed2: make([]float32, n), ground: make([]float32, n),
+	}
+	for d := range s.flux {
+		s.flux[d] = make([]float32, n)
+	}
+	return s
+}
+
+// 按行带并行遍历所有点
+func (s *PipeSim) eachCell(fn func(x, y, idx int)) {
+	s.topo.EachRowBand(func(_, y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < s.Width; x++ {
+				fn(x, y, x+y*s.Width)
+			}
+		}
+	})
+}
+
+// 方向d上的邻居 超出地图返回-1
+func (s *PipeSim) neighbor(x, y, d int) int {
+	switch d {
+	case pipeLeft:
+		x--
+	case pipeRight:
+		x++
+	case pipeTop:
+		y--
+	default:
+		y++
+	}
+	if x < 0 || y < 0 || x >= s.Width || y >= s.Height {
+		return -1
+	}
+	return x + y*s.Width
+}
+
+// 模拟steps步
+func (s *PipeSim) Run(steps int) {
+	for i := 0; i < steps; i++ {
+		s.Step()
+	}
+}
+
+func (s *PipeSim) Step() {
+	c, b := s.conf, s.topo.Data
+	dt := c.Dt
+
+	// 1 降水
+	s.eachCell(func(_, _, idx int) {
+		rain := c.Rain
+		if s.RainMap != nil {
+			rain *= s.RainMap[idx]
+		}
+		s.Water[idx] += rain * dt
+	})
+
+	// 2 管道流量 由水面高度差加速 总流出不超过本点水量
+	s.eachCell(func(x, y, idx int) {
+		var sum float32
+		for d := range s.flux {
+			f := float32(0)
+			if ni := s.neighbor(x, y, d); ni >= 0 {
+				dh := b[idx] + s.Water[idx] - b[ni] - s.Water[ni]
+				f = s.flux[d][idx] + dt*c.PipeArea*c.Gravity*dh
+				if f < 0 {
+					f = 0
+				}
+			}
+			s.flux[d][idx] = f
+			sum += f
+		}
+		if sum*dt > s.Water[idx] && sum > 0 {
+			k := s.Water[idx] / (sum * dt)
+			for d := range s.flux {
+				s.flux[d][idx] *= k
+			}
+		}
+	})
+
+	// 3 流入减流出得到新水深 由通过的流量得到速度
+	s.eachCell(func(x, y, idx int) {
+		var in, out float32
+		var through [4]float32 // 从各方向流入的量
+		for d := range s.flux {
+			out += s.flux[d][idx]
+			if ni := s.neighbor(x, y, d); ni >= 0 {
+				through[d] = s.flux[d^1][ni]
+				in += through[d]
+			}
+		}
+		w2 := s.Water[idx] + dt*(in-out)
+		if w2 < 0 {
+			w2 = 0
+		}
+		s.water2[idx] = w2
+		mean := (s.Water[idx] + w2) / 2
+		s.VelX[idx], s.VelY[idx] = 0, 0
+		if mean > 1e-4 {
+			s.VelX[idx] = (through[pipeLeft] - s.flux[pipeLeft][idx] + s.flux[pipeRight][idx] - through[pipeRight]) / 2 / mean
+			s.VelY[idx] = (through[pipeTop] - s.flux[pipeTop][idx] + s.flux[pipeBottom][idx] - through[pipeBottom]) / 2 / mean
+		}
+	})
+	s.Water, s.water2 = s.water2, s.Water
+
+	if c.Capacity > 0 {
+		s.transport()
+	}
+
+	// 蒸发
+	keep := 1 - c.Evaporation*dt
+	s.eachCell(func(_, _, idx int) {
+		s.Water[idx] *= keep
+	})
+}
+
+// 4 按携沙能力侵蚀或沉积 5 泥沙沿速度场半拉格朗日平流
+func (s *PipeSim) transport() {
+	c, b := s.conf, s.topo.Data
+	floor := s.topo.Clamp == terrain.ClampFloor
+	s.eachCell(func(x, y, idx int) {
+		gx := (s.groundAt(b, x+1, y) - s.groundAt(b, x-1, y)) / 2
+		gy := (s.groundAt(b, x, y+1) - s.groundAt(b, x, y-1)) / 2
+		g2 := gx*gx + gy*gy
+		tilt := float32(math.Sqrt(float64(g2 / (1 + g2))))
+		if tilt < c.MinTilt {
+			tilt = c.MinTilt
+		}
+		speed := float32(math.Hypot(float64(s.VelX[idx]), float64(s.VelY[idx])))
+		capacity := c.Capacity * tilt * speed
+		h, sed := b[idx], s.Sediment[idx]
+		if capacity > sed {
+			amount := c.Dissolve * (capacity - sed)
+			if floor && amount > h {
+				amount = h
+			}
+			h, sed = h-amount, sed+amount
+		} else {
+			amount := c.Deposit * (sed - capacity)
+			h, sed = h+amount, sed-amount
+		}
+		s.ground[idx], s.sed2[idx] = h, sed
+	})
+	s.eachCell(func(_, _, idx int) {
+		s.topo.SetHeight(idx, s.ground[idx])
+	})
+
+	s.eachCell(func(x, y, idx int) {
+		px := float32(x) - s.VelX[idx]*c.Dt
+		py := float32(y) - s.VelY[idx]*c.Dt
+		s.Sediment[idx] = bilinear(s.sed2, s.Width, s.Height, px, py)
+	})
+}
+
+// 地图外取边缘的值
+func (s *PipeSim) groundAt(b []float32, x, y int) float32 {
+	if x < 0 {
+		x = 0
+	} else if x >= s.Width {
+		x = s.Width - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= s.Height {
+		y = s.Height - 1
+	}
+	return b[x+y*s.Width]
+}
+
+func bilinear(data []float32, w, h int, x, y float32) float32 {
+	if x < 0 {
+		x = 0
+	} else if x > float32(w-1) {
+		x = float32(w - 1)
+	}
+	if y < 0 {
+		y = 0
+	} else if y > float32(h-1) {
+		y = float32(h - 1)
+	}
+	x0, y0 := int(x), int(y)
+	x1, y1 := x0+1, y0+1
+	if x1 >= w {
+		x1 = w - 1
+	}
+	if y1 >= h {
+		y1 = h - 1
+	}
+	u, v := x-float32(x0), y-float32(y0)
+	return data[x0+y0*w]*(1-u)*(1-v) + data[x1+y0*w]*u*(1-v) + data[x0+y1*w]*(1-u)*v + data[x1+y1*w]*u*v
+}
+
+// 总水量 按行累加后合并 结果与调度无关
+func (s *PipeSim) Volume() float64 {
+	rows := make([]float64, s.Height)
+	s.topo.EachRowBand(func(_, y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for _, d := range s.Water[y*s.Width : (y+1)*s.Width] {
+				rows[y] += float64(d)
+			}
+		}
+	})
+	var v float64
+	for _, r := range rows {
+		v += r
+	}
+	return v
+}
+
+// 把水深和流速写入WaterMap 水深写入积水H 流速方向写入场向量
+func (w *WaterMap) ApplyPipes(s *PipeSim) {
+	for idx := range w.Data {
+		dot := &w.Data[idx]
+		dot.H = int(s.Water[idx] + 0.5)
+		dot.XPower, dot.YPower = 0, 0
+		if l := math.Hypot(float64(s.VelX[idx]), float64(s.VelY[idx])); l > 0 {
+			dot.XPower, dot.YPower = float32(float64(s.VelX[idx])/l), float32(float64(s.VelY[idx])/l)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package hydro
+
+import "sort"
+
+// 河道折线和湖泊轮廓上的点 单位为地图坐标
+type Point struct {
+	X, Y float32
+}
+
+// 两个汇流点之间的一段河道
+type RiverSegment struct {
+	ID         int
+	Name       string  // 留给下游工具命名
+	Cells      []int   // 从上游到下游经过的点 不含下游汇流点
+	Points     []Point // 折线 末尾包含下游汇流点或入海口
+	Strahler   int     // Strahler河流等级 源头为1 两条同级汇合升一级
+	Shreve     int     // Shreve量级 上游源头数量
+	Upstream   []int   // 上游河段ID
+	Downstream int     // 下游河段ID -1表示入海 流出地图或分流后汇流量低于阈值
+	Acc        float32 // 末端的汇流量
+}
+
+// 由汇流提取的河网
+type RiverNetwork struct {
+	Segments []*RiverSegment
+	Width    int
+	Height   int
+	segOf    []int // 每个点所属的河段ID -1表示不是河道
+}
+
+// 汇流量不小于minAcc的点视为河道 沿主流向从源头追踪到河口
+// 在源头和汇流点切分河段 并计算Strahler和Shreve等级
+// 分流时下游点的汇流量可能低于minAcc 河段在此结束 不会与其他河段重叠
+func ExtractRivers(f *FlowField, minAcc float32) *RiverNetwork {
+	n := &RiverNetwork{Width: f.Width, Height: f.Height, segOf: make([]int, len(f.Dir))}
+	for idx := range n.segOf {
+		n.segOf[idx] = -1
+	}
+	isRiver := func(idx int) bool { return f.Acc[idx] >= minAcc }
+
+	// 每个河道点的上游河道数
+	upNum := make([]int8, len(f.Dir))
+	for idx := range f.Dir {
+		if di := f.Downstream(idx); di >= 0 && isRiver(idx) && isRiver(di) {
+			upNum[di]++
+		}
+	}
+
+	// 拓扑顺序保证上游河段先于下游创建
+	for _, idx := range f.order {
+		if !isRiver(idx) || upNum[idx] == 1 {
+			continue
+		}
+		seg := &RiverSegment{ID: len(n.Segments), Downstream: -1}
+		n.Segments = append(n.Segments, seg)
+		if upNum[idx] == 0 {
+			seg.Strahler, seg.Shreve = 1, 1
+		} else {
+			n.linkUpstream(f, seg, idx)
+		}
+		// 沿主流向追踪到下一个汇流点或河口
+		for ci := idx; ; {
+			n.segOf[ci] = seg.ID
+			seg.Cells = append(seg.Cells, ci)
+			seg.Points = append(seg.Points, n.point(ci))
+			seg.Acc = f.Acc[ci]
+			di := f.Downstream(ci)
+			if di < 0 || !isRiver(di) {
+				break
+			}
+			if upNum[di] > 1 {
+				seg.Points = append(seg.Points, n.point(di))
+				break
+			}
+			ci = di
+		}
+	}
+	return n
+}
+
+// 汇流点处的河段 找到所有上游河段并计算等级
+func (n *RiverNetwork) linkUpstream(f *FlowField, seg *RiverSegment, idx int) {
+	x, y := idx%f.Width, idx/f.Width
+	top, second := 0, 0
+	for _, off := range d8Offsets {
+		nx, ny := x+off[0], y+off[1]
+		if nx < 0 || ny < 0 || nx >= f.Width || ny >= f.Height {
+			continue
+		}
+		ni := nx + ny*f.Width
+		ui := n.segOf[ni]
+		if ui < 0 || f.Downstream(ni) != idx {
+			continue
+		}
+		up := n.Segments[ui]
+		up.Downstream = seg.ID
+		seg.Upstream = append(seg.Upstream, ui)
+		seg.Shreve += up.Shreve
+		if up.Strahler > top {
+			top, second = up.Strahler, top
+		} else if up.Strahler > second {
+			second = up.Strahler
+		}
+	}
+	seg.Strahler = top
+	if second == top {
+		seg.Strahler++
+	}
+}
+
+func (n *RiverNetwork) point(idx int) Point {
+	return Point{float32(idx%n.Width) + 0.5, float32(idx/n.Width) + 0.5}
+}
+
+// 经过(x,y)的河段 不是河道返回nil
+func (n *RiverNetwork) SegmentAt(x, y int) *RiverSegment {
+	if x < 0 || y < 0 || x >= n.Width || y >= n.Height {
+		return nil
+	}
+	if si := n.segOf[x+y*n.Width]; si >= 0 {
+		return n.Segments[si]
+	}
+	return nil
+}
+
+// 入海或流出地图的河段 按汇流量从大到小
+func (n *RiverNetwork) Mouths() []*RiverSegment {
+	var mouths []*RiverSegment
+	for _, seg := range n.Segments {
+		if seg.Downstream < 0 {
+			mouths = append(mouths, seg)
+		}
+	}
+	sort.SliceStable(mouths, func(i, j int) bool { return mouths[i].Acc > mouths[j].Acc })
+	return mouths
+}
+
+// 从seg到河口经过的所有河段 包括seg
+func (n *RiverNetwork) PathToMouth(seg *RiverSegment) []*RiverSegment {
+	path := []*RiverSegment{seg}
+	for seg.Downstream >= 0 {
+		seg = n.Segments[seg.Downstream]
+		path = append(path, seg)
+	}
+	return path
+}
+
+// 最大的Strahler等级
+func (n *RiverNetwork) MaxStrahler() int {
+	max := 0
+	for _, seg := range n.Segments {
+		if seg.Strahler > max {
+			max = seg.Strahler
+		}
+	}
+	return max
+}
@@ -0,0 +1,88 @@
+package hydro
+
+import "testing"
+
+// 5x5的手工河网 A(0,0)和B(4,0)在(2,2)汇合 C(4,3)在(2,3)汇入 从(2,4)流出
+func handRiverField() *FlowField {
+	const w, h = 5, 5
+	f := &FlowField{Width: w, Height: h, Dir: make([]int8, w*h), Acc: make([]float32, w*h)}
+	for idx := range f.Dir {
+		f.Dir[idx] = FlowOutlet
+	}
+	at := func(x, y int) int { return x + y*w }
+	flow := []struct {
+		x, y int
+		dir  int8
+	}{
+		{0, 0, 1}, {1, 1, 1}, {4, 0, 3}, {3, 1, 3}, {4, 3, 4}, {3, 3, 4},
+		{2, 2, 2}, {2, 3, 2}, {2, 4, FlowOutlet},
+	}
+	for _, c := range flow {
+		idx := at(c.x, c.y)
+		f.Dir[idx] = c.dir
+		f.Acc[idx] = 1
+		f.order = append(f.order, idx)
+	}
+	return f
+}
+
+func TestExtractRiversOrders(t *testing.T) {
+	n := ExtractRivers(handRiverField(), 1)
+	if len(n.Segments) != 5 {
+		t.Fatalf("segment num: %d != 5", len(n.Segments))
+	}
+	want := []struct {
+		x, y              int
+		cells             int
+		strahler, shreve  int
+		upstream, hasDown bool
+	}{
+		{0, 0, 2, 1, 1, false, true},
+		{4, 0, 2, 1, 1, false, true},
+		{4, 3, 2, 1, 1, false, true},
+		{2, 2, 1, 2, 2, true, true},
+		{2, 3, 2, 2, 3, true, false},
+	}
+	for _, c := range want {
+		seg := n.SegmentAt(c.x, c.y)
+		if seg == nil || seg.Cells[0] != c.x+c.y*n.Width {
+			t.Fatalf("no segment starts at (%d,%d)", c.x, c.y)
+		}
+		if len(seg.Cells) != c.cells || seg.Strahler != c.strahler || seg.Shreve != c.shreve {
+			t.Errorf("segment at (%d,%d): cells %d strahler %d shreve %d, want %d %d %d",
+				c.x, c.y, len(seg.Cells), seg.Strahler, seg.Shreve, c.cells, c.strahler, c.shreve)
+		}
+		if (len(seg.Upstream) > 0) != c.upstream || (seg.Downstream >= 0) != c.hasDown {
+			t.Errorf("segment at (%d,%d): upstream %v downstream %d", c.x, c.y, seg.Upstream, seg.Downstream)
+		}
+	}
+}
+
+// 分流时河段不能重叠 也不能经过低于阈值的点
+func TestExtractRiversNoOverlap(t *testing.T) {
+	m, _, _ := buildSeededMap(t, 42)
+	for _, mode := range []RoutingMode{RoutingD8, RoutingDinf, RoutingMFD} {
+		f := NewFlowField(m, nil, Routing{Mode: mode})
+		const minAcc = 30
+		n := ExtractRivers(f, minAcc)
+		owner := make(map[int]int)
+		for _, seg := range n.Segments {
+			for _, idx := range seg.Cells {
+				if prev, ok := owner[idx]; ok {
+					t.Fatalf("mode %d: cell %d in segment %d and %d", mode, idx, prev, seg.ID)
+				}
+				owner[idx] = seg.ID
+				if f.Acc[idx] < minAcc {
+					t.Fatalf("mode %d: cell %d below threshold in segment %d", mode, idx, seg.ID)
+				}
+			}
+			shreve := 0
+			for _, ui := range seg.Upstream {
+				shreve += n.Segments[ui].Shreve
+			}
+			if len(seg.Upstream) > 0 && seg.Shreve != shreve {
+				t.Errorf("mode %d: segment %d shreve %d != sum of upstream %d", mode, seg.ID, seg.Shreve, shreve)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package hydro
+
+import (
+	"math"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 沿汇流搬运泥沙的参数 河流的侵蚀和携沙能力都随流量和坡度增大
+// 能力下降处(坡度突变 平坦谷底 湖 入海口)泥沙沉积 形成冲积扇 泛滥平原和三角洲
+type SedimentConfig struct {
+	Erodibility float32 // 侵蚀系数 默认0.02
+	Transport   float32 // 携沙能力系数 默认0.2
+	AccExp      float64 // 流量的指数 默认0.5
+	Deposition  float32 // 超出能力部分每点沉积的比例 0-1 默认0.5 越小沉积带越长
+	DeltaRadius int     // 入海口泥沙在海中扩散的最大距离 默认15
+}
+
+func (c *SedimentConfig) setDefaults() {
+	if c.Erodibility <= 0 {
+		c.Erodibility = 0.02
+	}
+	if c.Transport <= 0 {
+		c.Transport = 0.2
+	}
+	if c.AccExp <= 0 {
+		c.AccExp = 0.5
+	}
+	if c.Deposition <= 0 || c.Deposition > 1 {
+		c.Deposition = 0.5
+	}
+	if c.DeltaRadius <= 0 {
+		c.DeltaRadius = 15
+	}
+}
+
+// 按拓扑顺序从上游到下游搬运泥沙 一遍完成 结果记入m的沉积层
+// 流出地图边缘的泥沙丢失 返回侵蚀和沉积的总量
+func RouteSediment(f *FlowField, m *terrain.Topomap, c SedimentConfig) (eroded, deposited float32) {
+	c.setDefaults()
+	m.TrackSediment()
+	load := make([]float32, len(m.Data))
+
+	for _, idx := range f.order {
+		if m.IsSea(idx) {
+			if load[idx] > 0 {
+				deposited += spreadDelta(m, idx, load[idx], c.DeltaRadius)
+			}
+			continue
+		}
+		di := f.Downstream(idx)
+		if di < 0 {
+			continue
+		}
+		drop := f.Filled[idx] - f.Filled[di]
+		slope := drop / d8Dist[f.Dir[idx]]
+		if slope < 0 {
+			slope = 0
+		}
+		q := float32(math.Pow(float64(f.Acc[idx]), c.AccExp))
+		capacity := c.Transport * q * slope
+		if l := load[idx]; l < capacity {
+			// 侵蚀不低于下游点 避免挖出洼地
+			e := c.Erodibility * q * slope
+			if e > capacity-l {
+				e = capacity - l
+			}
+			if e > drop/2 {
+				e = drop / 2
+			}
+			if e > 0 {
+				old := m.Data[idx]
+				m.Lower(idx, e)
+				load[idx] += old - m.Data[idx]
+				eroded += old - m.Data[idx]
+			}
+		} else {
+			d := (l - capacity) * c.Deposition
+			m.Deposit(idx, d)
+			load[idx] -= d
+			deposited += d
+		}
+		f.eachReceiver(idx, func(ni int, frac float32) {
+			load[ni] += load[idx] * frac
+		})
+	}
+	return eroded, deposited
+}
+
+// 入海的泥沙从河口向外由近到远填到海平面以下 填不下的被冲到远海
+func spreadDelta(m *terrain.Topomap, start int, amount float32, radius int) (deposited float32) {
+	const margin = 0.05
+	sx, sy := start%m.Width, start/m.Width
+	seen := map[int]bool{start: true}
+	queue := []int{start}
+	for qi := 0; qi < len(queue) && amount > 0; qi++ {
+		idx := queue[qi]
+		if room := m.SeaLevel - margin - m.Data[idx]; room > 0 {
+			d := room
+			if d > amount {
+				d = amount
+			}
+			m.Deposit(idx, d)
+			amount -= d
+			deposited += d
+		}
+		x, y := idx%m.Width, idx/m.Width
+		for _, off := range d8Offsets {
+			nx, ny := x+off[0], y+off[1]
+			if nx < 0 || ny < 0 || nx >= m.Width || ny >= m.Height {
+				continue
+			}
+			if abs(nx-sx) > radius || abs(ny-sy) > radius {
+				continue
+			}
+			ni := nx + ny*m.Width
+			if !seen[ni] && m.IsSea(ni) {
+				seen[ni] = true
+				queue = append(queue, ni)
+			}
+		}
+	}
+	return deposited
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
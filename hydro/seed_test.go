@@ -0,0 +1,88 @@
+package hydro
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uxff/topograph-maker/terrain"
+	"gopkg.in/yaml.v2"
+)
+
+const seedTestLayout = `
+ridgegroup:
+  list:
+    - num: 4
+      wide: 30
+      len: 20
+      high: 10
+  petalflag:
+    shape: 1
+    petalnum: 3
+    sharp: 0.3
+hillgroup:
+  list:
+    - num: 40
+      wide: 30
+  petalflag:
+    shape: 3
+    petalnum: 3
+    sharp: 0.5
+`
+
+// 按seed生成hill ridge 再移动水滴 与appv4的顺序一致
+func buildSeededMap(t *testing.T, seed int64) (*terrain.Topomap, *WaterMap, []*Droplet) {
+	conf := &terrain.LayoutConfig{}
+	if err := yaml.Unmarshal([]byte(seedTestLayout), conf); err != nil {
+		t.Fatal(err)
+	}
+	const width, height = 120, 100
+	m := terrain.NewTopomap(width, height)
+	hills := conf.HillGroup.ToHills(terrain.NewStageRand(seed, "hill"), width, height)
+	ridges := conf.RidgeGroup.ToRidgeHills(terrain.NewStageRand(seed, "ridge"), width, height)
+	m.FillHills(
+		terrain.HillLayer{Hills: ridges, PetalFlag: &conf.RidgeGroup.PetalFlag},
+		terrain.HillLayer{Hills: hills, PetalFlag: &conf.HillGroup.PetalFlag},
+	)
+
+	w := NewWaterMap(m)
+	w.AssignVector(3)
+	drops := MakeDroplets(terrain.NewStageRand(seed, "droplet"), w, 37, nil)
+	drops = DropletsMove(context.Background(), 200, drops, w)
+	return m, w, drops
+}
+
+func TestSameSeedSameMap(t *testing.T) {
+	m1, w1, d1 := buildSeededMap(t, 42)
+	m2, w2, d2 := buildSeededMap(t, 42)
+
+	for i := range m1.Data {
+		if m1.Data[i] != m2.Data[i] {
+			t.Fatalf("height differs at %d: %f != %f", i, m1.Data[i], m2.Data[i])
+		}
+	}
+	for i := range w1.Data {
+		a, b := w1.Data[i], w2.Data[i]
+		if a.Q != b.Q || a.H != b.H || a.XPower != b.XPower || a.YPower != b.YPower {
+			t.Fatalf("water differs at %d: %+v != %+v", i, a, b)
+		}
+	}
+	if len(d1) != len(d2) {
+		t.Fatalf("droplet num differs: %d != %d", len(d1), len(d2))
+	}
+	for i := range d1 {
+		if d1[i].X != d2[i].X || d1[i].Y != d2[i].Y || d1[i].Sediment != d2[i].Sediment {
+			t.Fatalf("droplet %d differs: (%f,%f) != (%f,%f)", i, d1[i].X, d1[i].Y, d2[i].X, d2[i].Y)
+		}
+	}
+}
+
+func TestDifferentSeedDifferentMap(t *testing.T) {
+	m1, _, _ := buildSeededMap(t, 1)
+	m2, _, _ := buildSeededMap(t, 2)
+	for i := range m1.Data {
+		if m1.Data[i] != m2.Data[i] {
+			return
+		}
+	}
+	t.Fatal("different seeds made the same map")
+}
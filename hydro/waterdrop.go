@@ -0,0 +1,271 @@
+package hydro
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+const (
+	DropsCohesiveDistSq = 9.0 // drops凝聚力距离平方
+	// 水滴之间的吸引力度 类似于万有引力常量
+	AttractPowerDecay = 0.25
+	// 距离平方超过这个值 就会被等比例缩减速度 但是保持方向
+	MinDistToReduce = 2
+)
+
+// 方案二(un done) 计算好地形场向量 没有流量向量
+// 随机撒水珠 水滴会移动 移动的时候会动态影响周边的其他水滴
+// 不断撒水滴 看看水滴运动趋势
+// 使用水滴滚动
+type Droplet struct {
+	X         float32
+	Y         float32
+	FallPower int     // 落差能量
+	Sediment  float32 // 冲刷带走的泥沙 停下时沉积
+	VX        float32 // 滑行速度
+	VY        float32
+	Hisway    []int
+
+	rnd *rand.Rand // 每个水滴独立的随机数 并行移动时结果不依赖调度
+}
+
+// 只读WaterMap和上一步所有水滴的位置prev 只修改d自己
+// 返回流出的点(计入流量) 移动成功时返回冲刷事件 都由调用方在步末按顺序应用
+func (d *Droplet) Move(w *WaterMap, prev []Point, step int) (visited int, e *ErodeEvent) {
+	m := w.topo
+	oldIdx := w.cellAt(d.X, d.Y)
+	if oldIdx < 0 {
+		log.Printf("oldIdx(%d) out of w.data. stop it.", oldIdx)
+		return -1, nil
+	}
+
+	d.VX, d.VY = d.VX+w.Data[oldIdx].XPower, d.VY+w.Data[oldIdx].YPower
+
+	// 距离平方在2以内的WaterDot有吸引力 // todo 使用分层数组索引
+	for i := step % 4; i < len(prev); i += 4 {
+		di := prev[i] // 几率变成4分之1 但是不会重复，会轮询
+		distSquare := (di.X-d.X)*(di.X-d.X) + (di.Y-d.Y)*(di.Y-d.Y)
+		if distSquare < DropsCohesiveDistSq {
+			// di 是在范围sqrt(8)以内的水滴
+			d.CloseTo(di, distSquare) // 靠近
+		}
+	}
+
+	// 没有场 可撒欢
+	if w.Data[oldIdx].XPower == 0 && w.Data[oldIdx].YPower == 0 {
+		// 自己生速度 比较浪
+		d.GenVeloByFallPower(d.rnd)
+	}
+
+	// 将超出的速度限制成标准速度
+	d.ReduceSpeed()
+
+	// 场速度与自身速度的平均值
+	tmpX := d.X + d.VX // todo:精度损失风险
+	tmpY := d.Y + d.VY
+
+	// 越界判断
+	if int(tmpX) < 0 || int(tmpX) > w.Width-1 || int(tmpY) < 0 || int(tmpY) > w.Height-1 {
+		log.Printf("droplet move out of bound(x=%f,y=%f). stop move.", tmpX, tmpY)
+		return oldIdx, nil
+	}
+
+	newIdx := int(tmpX) + int(tmpY)*w.Width
+	// 无力场，待在原地
+	if newIdx == oldIdx {
+		return oldIdx, nil
+	}
+
+	if newIdx >= w.Width*w.Height {
+		log.Printf("newIdx(%d) out of data range, ignore", newIdx)
+		return oldIdx, nil
+	}
+
+	// 不跑到高处
+	if float32(w.Data[newIdx].H)+m.Data[newIdx] > float32(w.Data[oldIdx].H)+m.Data[oldIdx] {
+		log.Printf("pos(%8d to %8d) is too high, stop, h:%d/%d", oldIdx, newIdx, w.Data[oldIdx].H, w.Data[newIdx].H)
+		return oldIdx, nil
+	}
+
+	d.X, d.Y = tmpX, tmpY
+	d.FallPower += int((m.Data[oldIdx] - m.Data[newIdx]) * 10)
+
+	return oldIdx, &ErodeEvent{oldIdx: oldIdx, newIdx: newIdx, drop: d}
+}
+
+// 根据落差能量移动 包括位置浮动和速度浮动 只更改droplet
+func (d *Droplet) GenVeloByFallPower(rnd *rand.Rand) {
+	if d.FallPower > 0 {
+		tmpRoll := rnd.Float32()
+		// 小于一定的几率才执行方向浮动
+		if tmpRoll < 0.5 {
+			// 要和PI有关系 否则都向右面走
+			tmpDir := (rnd.Float64() - rnd.Float64()) * math.Pi * 2
+			fx, fy := float32(math.Cos(tmpDir)), float32(math.Sin(tmpDir))
+			d.VX, d.VY = d.VX+fx/4.0, d.VY+fy/4.0
+		}
+
+		// 在一定几率下 位移浮动
+		if tmpRoll < 0.5 {
+			fx, fy := rnd.Float32()-tmpRoll, rnd.Float32()-tmpRoll
+			d.X, d.Y = d.X+fx/1.0, d.Y+fy/1.0
+		}
+
+		d.FallPower--
+	}
+}
+
+// 会改变d的方向 即会改变 vx,vy 值
+func (d *Droplet) CloseTo(target Point, distSquare float32) {
+	distSquareRoot := math.Sqrt(float64(distSquare))
+	d.VX = d.VX + (target.X-d.X)*float32(distSquareRoot)*AttractPowerDecay
+	d.VY = d.VY + (target.Y-d.Y)*float32(distSquareRoot)*AttractPowerDecay
+}
+
+func (d *Droplet) ReduceSpeed() {
+	vSquare := d.VX*d.VX + d.VY*d.VY
+	if vSquare > MinDistToReduce {
+		scale := float32(math.Sqrt(float64(vSquare / MinDistToReduce)))
+		d.VX, d.VY = d.VX/scale, d.VY/scale
+	}
+}
+
+// 将坐标是0的清理出数组
+func ClearDroplets(drops []*Droplet) []*Droplet {
+	newDrops := make([]*Droplet, 0)
+	for idx, d := range drops {
+		if d.VX == 0 && d.VY == 0 {
+			log.Printf("clear:[%d]=%+v", idx, *d)
+			continue
+		}
+		newDrops = append(newDrops, d)
+	}
+	return newDrops
+}
+
+// 一个水滴在一步中的结果
+type dropStep struct {
+	visited int
+	event   *ErodeEvent
+}
+
+// 双缓冲地移动水滴 每一步先记下所有水滴的位置 各水滴只读这份快照和WaterMap 只写自己 按分片并行
+// 步末再按drops的顺序统一累加流量 应用冲刷事件 卸下停住水滴的泥沙
+// 同样的初始状态总是得到同样的结果 ctx取消时在步与步之间退出 不遗留goroutine
+func DropletsMove(ctx context.Context, times int, drops []*Droplet, w *WaterMap) []*Droplet {
+	prev := make([]Point, len(drops))
+	results := make([]dropStep, len(drops))
+	workerNum := runtime.NumCPU()
+	chunk := (len(drops) + workerNum - 1) / workerNum
+
+	for i := 1; i <= times; i++ {
+		select {
+		case <-ctx.Done():
+			log.Printf("droplets move canceled at step %d: %v", i, ctx.Err())
+			return drops
+		default:
+		}
+
+		for di, d := range drops {
+			prev[di] = Point{d.X, d.Y}
+		}
+
+		wg := sync.WaitGroup{}
+		for c0 := 0; c0 < len(drops); c0 += chunk {
+			c1 := c0 + chunk
+			if c1 > len(drops) {
+				c1 = len(drops)
+			}
+			wg.Add(1)
+			go func(c0, c1, step int) {
+				defer wg.Done()
+				for di := c0; di < c1; di++ {
+					results[di].visited, results[di].event = drops[di].Move(w, prev, step)
+				}
+			}(c0, c1, i)
+		}
+		wg.Wait()
+
+		for di, r := range results {
+			d := drops[di]
+			if r.visited >= 0 {
+				w.Data[r.visited].Q++ // 流出，才算流量
+			}
+			if r.event != nil {
+				w.applyErodeEvent(r.event)
+				d.Hisway = append(d.Hisway, r.event.newIdx)
+			} else if d.Sediment > 0 {
+				// 停下的水滴把泥沙卸在原地 离开地图的泥沙丢失
+				if idx := w.cellAt(d.X, d.Y); idx >= 0 {
+					w.topo.Deposit(idx, d.Sediment)
+				}
+				d.Sediment = 0
+			}
+		}
+	}
+	return drops
+}
+
+// 坐标所在的点 在地图外返回-1
+func (w *WaterMap) cellAt(x, y float32) int {
+	if x < 0 || y < 0 || int(x) >= w.Width || int(y) >= w.Height {
+		return -1
+	}
+	return int(x) + int(y)*w.Width
+}
+
+func MakeDroplet(rnd *rand.Rand, w *WaterMap) *Droplet {
+	return newDroplet(rnd, w, rnd.Int()%len(w.Data))
+}
+
+// 按降水场投放num个水滴 降水越多的点越容易落下水滴 rain为nil时均匀投放
+func MakeDroplets(rnd *rand.Rand, w *WaterMap, num int, rain []float32) []*Droplet {
+	drops := make([]*Droplet, num)
+	if rain == nil {
+		for di := range drops {
+			drops[di] = MakeDroplet(rnd, w)
+		}
+		return drops
+	}
+	// 累积分布 二分查找落点
+	cdf := make([]float64, len(rain))
+	var sum float64
+	for i, r := range rain {
+		if r > 0 {
+			sum += float64(r)
+		}
+		cdf[i] = sum
+	}
+	if sum <= 0 {
+		return drops[:0]
+	}
+	for di := range drops {
+		idx := sort.SearchFloat64s(cdf, rnd.Float64()*sum)
+		if idx >= len(cdf) {
+			idx = len(cdf) - 1
+		}
+		drops[di] = newDroplet(rnd, w, idx)
+	}
+	return drops
+}
+
+func newDroplet(rnd *rand.Rand, w *WaterMap, idx int) *Droplet {
+	d := Droplet{
+		X:         float32(idx%w.Width) + 0.5,
+		Y:         float32(idx/w.Width) + 0.5,
+		Hisway:    []int{idx},
+		FallPower: 2,
+		rnd:       rand.New(rand.NewSource(rnd.Int63())),
+	}
+
+	w.Data[idx].H++
+
+	thedir := rnd.Float64() * math.Pi * 2
+	d.VX, d.VY = float32(math.Cos(thedir))/2, float32(math.Sin(thedir))/2
+	return &d
+}
@@ -0,0 +1,250 @@
+// Package hydro 水文 在terrain.Topomap上撒水滴 计算地形场 水流冲刷地形
+package hydro
+
+import (
+	"log"
+	"math"
+
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 将变成固定不移动 记录场 被流动水雕刻
+type WaterDot struct {
+	X      float32 // 将不变化 =Topomap[x,y] +(0.5, 0.5)
+	Y      float32
+	XPower float32 // x方向速度增益 场强度 v2 根据地形得出 初始化后不变(地形改变则会变) 基于Atan2 范围(-1,1)
+	YPower float32 // y方向速度增益 场强度 v2 根据地形得出 初始化后不变(地形改变则会变)
+	H      int     // 积水高度，产生积水不参与流动，流动停止 // v2将由水滴实体代替该变量
+	Q      int     // 流量 0=无 历史流量
+}
+
+type WaterMap struct {
+	Data   []WaterDot // slice的idx不再是pos
+	Width  int
+	Height int
+
+	topo       *terrain.Topomap
+	evtIdx     int
+	topoEvtIdx int
+}
+
+// 改变地形的事件 按水滴顺序在每一步结束时应用 保证结果可复现
+type ErodeEvent struct {
+	oldIdx int
+	newIdx int
+	drop   *Droplet
+}
+
+// 创建与m同尺寸的WaterMap 冲刷事件作用于m
+func NewWaterMap(m *terrain.Topomap) *WaterMap {
+	w := &WaterMap{
+		Data:   make([]WaterDot, m.Width*m.Height),
+		Width:  m.Width,
+		Height: m.Height,
+		topo:   m,
+	}
+	// 把点的实际基点摆在中间
+	for x := 0; x < w.Width; x++ {
+		for y := 0; y < w.Height; y++ {
+			w.Data[x+y*w.Width].X = float32(x) + 0.5
+			w.Data[x+y*w.Width].Y = float32(y) + 0.5
+		}
+	}
+	return w
+}
+
+// 先处理场向量 // 再注水流动
+
+// 预先处理每个点的场向量  只计算地势的影响，不考虑流量的影响
+// 假设每个点都有一个场，计算出这个场的方向
+// 启动只执行1次
+// @param int ring 表示计算到几环 默认2环
+func (w *WaterMap) AssignVector(ring int) {
+	m := w.topo
+	for idx, curDot := range w.Data {
+		var xPower, yPower int // xPower, yPower 单位为1
+		// 2nd ring
+		_, lowestPos := curDot.getLowestNeighbors(curDot.getNeighbors(), m)
+		for _, neiPos := range lowestPos {
+			xPower += 4 * (neiPos.x - int(idx%w.Width))
+			yPower += 4 * (neiPos.y - int(idx/w.Width))
+		}
+
+		// 3rd ring. done 三环的影响力是二环的1/4
+		if ring >= 3 {
+			_, lowestPos = curDot.getLowestNeighbors(curDot.get3rdNeighbors(), m)
+			for _, neiPos := range lowestPos {
+				xPower += neiPos.x - int(idx%w.Width)
+				yPower += neiPos.y - int(idx/w.Width)
+			}
+		}
+
+		// 四环 四环影响力是二环的1/16 暂不实现4环
+
+		if xPower != 0 || yPower != 0 {
+			thedir := math.Atan2(float64(yPower), float64(xPower))
+			w.Data[idx].XPower, w.Data[idx].YPower = float32(math.Cos(thedir)), float32(math.Sin(thedir))
+		}
+	}
+}
+
+// 按照周围流量更新场向量
+// powerRate 一般指定小于1 比如0.1
+func (w *WaterMap) UpdateVectorByQuantity(ring int, powerRate float32) {
+	for idx, curDot := range w.Data {
+		var xPower, yPower int // xPower, yPower 单位为1
+		// 2nd ring
+		_, mostQuanPos := curDot.getPostQuanNeighbors(curDot.getNeighbors(), w)
+		for _, neiPos := range mostQuanPos {
+			xPower += 4 * (neiPos.x - int(idx%w.Width))
+			yPower += 4 * (neiPos.y - int(idx/w.Width))
+		}
+
+		// 3rd ring. done 三环的影响力是二环的1/4
+		if ring >= 3 {
+			_, mostQuanPos = curDot.getPostQuanNeighbors(curDot.get3rdNeighbors(), w)
+			for _, neiPos := range mostQuanPos {
+				xPower += neiPos.x - int(idx%w.Width)
+				yPower += neiPos.y - int(idx/w.Width)
+			}
+		}
+	}
+}
+
+// 应用一个冲刷事件 改变watermap的积水 并冲刷topomap
+func (w *WaterMap) applyErodeEvent(e *ErodeEvent) {
+	w.evtIdx++
+	w.Data[e.newIdx].H++
+	w.Data[e.oldIdx].H--
+
+	if e.oldIdx != e.newIdx {
+		w.erodeTopo(e)
+	}
+
+	if w.evtIdx%100 == 0 {
+		w.UpdateVectorByQuantity(2, 0.2)
+	}
+}
+
+// 冲刷地形
+func (w *WaterMap) erodeTopo(e *ErodeEvent) {
+	m := w.topo
+	w.topoEvtIdx++
+	e.drop.Sediment += w.lowerTopo(e.oldIdx)
+	// todo 这里有bug
+	neis := w.Data[e.oldIdx].getNeighbors()
+	for nei := range neis {
+		if neis[nei].y >= m.Height || neis[nei].x >= m.Width {
+			continue
+		}
+		e.drop.Sediment += w.lowerTopo(neis[nei].y*m.Width + neis[nei].x)
+	}
+}
+
+// 冲刷一个点 返回被带走的量
+func (w *WaterMap) lowerTopo(idx int) float32 {
+	old := w.topo.Data[idx]
+	w.topo.Lower(idx, 1)
+	return old - w.topo.Data[idx]
+}
+
+func (w *WaterMap) SumH() int {
+	h := 0
+	for idx := range w.Data {
+		h += w.Data[idx].H
+	}
+	return h
+}
+
+// 已处理的事件数 分别是watermap和topomap的
+func (w *WaterMap) EventCount() (water, topo int) {
+	return w.evtIdx, w.topoEvtIdx
+}
+
+// 此函数固定返回本坐标周边2环8个边界点，可能包含超出地图边界的点
+func (d *WaterDot) getNeighbors() []struct{ x, y int } {
+	pos := make([]struct{ x, y int }, 8)
+	pos[0].x, pos[0].y = int(d.X+1), int(d.Y+0)
+	pos[1].x, pos[1].y = int(d.X+1), int(d.Y-1)
+	pos[2].x, pos[2].y = int(d.X+0), int(d.Y-1)
+	pos[3].x, pos[3].y = int(d.X-1), int(d.Y-1)
+	pos[4].x, pos[4].y = int(d.X-1), int(d.Y+0)
+	pos[5].x, pos[5].y = int(d.X-1), int(d.Y+1)
+	pos[6].x, pos[6].y = int(d.X+0), int(d.Y+1)
+	pos[7].x, pos[7].y = int(d.X+1), int(d.Y+1)
+	return pos
+}
+
+// 此函数固定返回本点周边3环12个边界点，可能包含超出地图边界的点
+func (d *WaterDot) get3rdNeighbors() []struct{ x, y int } {
+	pos := make([]struct{ x, y int }, 12)
+	// right
+	pos[0].x, pos[0].y = int(d.X+2), int(d.Y)
+	pos[1].x, pos[1].y = int(d.X+2), int(d.Y-1)
+	// top
+	pos[2].x, pos[2].y = int(d.X+1), int(d.Y-2)
+	pos[3].x, pos[3].y = int(d.X), int(d.Y-2)
+	pos[4].x, pos[4].y = int(d.X-1), int(d.Y-2)
+	// left
+	pos[5].x, pos[5].y = int(d.X-2), int(d.Y-1)
+	pos[6].x, pos[6].y = int(d.X-2), int(d.Y)
+	pos[7].x, pos[7].y = int(d.X-2), int(d.Y+1)
+	// bottom
+	pos[8].x, pos[8].y = int(d.X-1), int(d.Y+2)
+	pos[9].x, pos[9].y = int(d.X), int(d.Y+2)
+	pos[10].x, pos[10].y = int(d.X+1), int(d.Y+2)
+	// right
+	pos[11].x, pos[11].y = int(d.X+2), int(d.Y+1)
+	return pos
+}
+
+/*获取周围最低的点 最低点集合数组中随机取一个 返回安全的坐标，不在地图外*/
+func (d *WaterDot) getLowestNeighbors(arrNei []struct{ x, y int }, m *terrain.Topomap) (lowestLevel float32, lowestPos []struct{ x, y int }) {
+	// 原理： highMap[high] = []struct{int,int}
+	highMap := make(map[float32][]struct{ x, y int }, 8)
+	for _, nei := range arrNei {
+		if nei.x < 0 || nei.x > m.Width-1 || nei.y < 0 || nei.y > m.Height-1 {
+			// 超出地图边界的点
+			continue
+		}
+		// 邻居的高度 todo: 有BUG 此处不能加本地的水位 要加邻居的水位
+		high := m.Data[nei.x+nei.y*m.Width] + float32(d.H)
+		highMap[high] = append(highMap[high], struct{ x, y int }{nei.x, nei.y})
+	}
+	lowestLevel = math.MaxFloat32
+	for k := range highMap {
+		if k < lowestLevel {
+			lowestLevel = k
+		}
+	}
+	if len(highMap[lowestLevel]) == 0 {
+		log.Printf("how is can be zero?")
+		return lowestLevel, nil
+	}
+	return lowestLevel, highMap[lowestLevel]
+}
+
+/*获取周围流量最大的点 流量最大的点集合数组中随机取一个 返回安全的坐标，不在地图外*/
+func (d *WaterDot) getPostQuanNeighbors(arrNei []struct{ x, y int }, w *WaterMap) (mostQuanLevel int, poses []struct{ x, y int }) {
+	// 原理： highMap[quantity] = []struct{int,int}
+	highMap := make(map[int][]struct{ x, y int }, 8)
+	for _, nei := range arrNei {
+		if nei.x < 0 || nei.x > w.Width-1 || nei.y < 0 || nei.y > w.Height-1 {
+			// 超出地图边界的点
+			continue
+		}
+		high := int(w.Data[nei.x+nei.y*w.Width].Q)
+		highMap[high] = append(highMap[high], struct{ x, y int }{nei.x, nei.y})
+	}
+	mostQuanLevel = 0
+	for k := range highMap {
+		if k > mostQuanLevel {
+			mostQuanLevel = k
+		}
+	}
+	if len(highMap[mostQuanLevel]) == 0 {
+		log.Printf("how is can be zero?")
+		return mostQuanLevel, nil
+	}
+	return mostQuanLevel, highMap[mostQuanLevel]
+}
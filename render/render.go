@@ -0,0 +1,502 @@
+// Package render 将Topomap和WaterMap绘制到图片或控制台
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"os"
+
+	"github.com/uxff/topograph-maker/climate"
+	"github.com/uxff/topograph-maker/hydro"
+	"github.com/uxff/topograph-maker/terrain"
+)
+
+// 等高线文件模板 取垂直第一列的像素
+const DefaultColorTplFile = "./image/color-tpl2.png"
+
+const (
+	// drawFlag 绘制参数
+	DrawFlagField  = 1 << iota // 绘制地形落差场
+	DrawFlagHisway             // 绘制水滴轨迹
+)
+
+// 绘制参数
+type Options struct {
+	ColorTplFile    string  // 颜色模板文件
+	ColorTplStep    int     // 忽略颜色模板中的前几行
+	Zoom            int     // 放大倍数
+	RiverArrowScale float64 // 场箭头长度比例
+	DrawFlag        int     // DrawFlagField|DrawFlagHisway
+	RiverQ          int     // 流量不小于此值才绘制为河流 0表示有流量就绘制
+	RiverWidth      float64 // 河网每升一级增加的线宽 单位为地图点 0表示1
+	BasinMinArea    int     // 面积不小于此值的流域才绘制
+
+	Biomes       []climate.Biome              // 不为nil时按群系着色 代替颜色模板
+	BiomePalette [climate.BiomeNum]color.RGBA // 各群系的颜色 由ParseBiomePalette得到
+}
+
+// 群系的默认颜色
+var DefaultBiomePalette = [climate.BiomeNum]color.RGBA{
+	climate.BiomeOcean:      {0x3a, 0x6e, 0xb5, 0xFF},
+	climate.BiomeBeach:      {0xee, 0xdc, 0xa0, 0xFF},
+	climate.BiomeDesert:     {0xd9, 0xb8, 0x6c, 0xFF},
+	climate.BiomeGrassland:  {0xa4, 0xc8, 0x5a, 0xFF},
+	climate.BiomeSavanna:    {0xc6, 0xc0, 0x5a, 0xFF},
+	climate.BiomeForest:     {0x3f, 0x8f, 0x3a, 0xFF},
+	climate.BiomeRainforest: {0x1b, 0x5e, 0x20, 0xFF},
+	climate.BiomeTaiga:      {0x4f, 0x7a, 0x5f, 0xFF},
+	climate.BiomeTundra:     {0xa8, 0xa8, 0x8c, 0xFF},
+	climate.BiomeSnow:       {0xf4, 0xf8, 0xfc, 0xFF},
+	climate.BiomeSwamp:      {0x5a, 0x6e, 0x3c, 0xFF},
+}
+
+// 由群系名到#rrggbb的配置得到调色板 未配置的群系用默认颜色
+func ParseBiomePalette(conf map[string]string) ([climate.BiomeNum]color.RGBA, error) {
+	palette := DefaultBiomePalette
+	for name, hex := range conf {
+		b, err := climate.ParseBiome(name)
+		if err != nil {
+			return palette, err
+		}
+		var r, g, bl uint8
+		if _, err := fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &bl); err != nil {
+			return palette, fmt.Errorf("bad color %q of biome %s: %v", hex, name, err)
+		}
+		palette[b] = color.RGBA{r, g, bl, 0xFF}
+	}
+	return palette, nil
+}
+
+/*返回颜色数组，下标越大颜色海拔越高*/
+func ColorTpl(colorTplFile string, colorTplStep int) []color.Color {
+	var colorTplFileIo, _ = os.Open(colorTplFile)
+	defer colorTplFileIo.Close()
+	var colorTplPng, err = png.Decode(colorTplFileIo)
+
+	if err != nil {
+		log.Println("png.decode err when read colorTpl:", err)
+		return nil
+	}
+
+	// 从colorTplStep以上的部分取
+	theLen := colorTplPng.Bounds().Dy() - colorTplStep
+	cs := make([]color.Color, theLen)
+	for i := 0; i < theLen; i++ {
+		cs[i] = colorTplPng.At(0, (theLen-i-1)-colorTplStep)
+	}
+	return cs
+}
+
+// 高度到颜色模板下标的映射 有海时海平面对齐模板中水和陆地的分界
+type colorRamp struct {
+	lo, hi, sea float32
+	seaIdx      int // 模板中水色的数量 0表示按高度线性映射
+	last        int
+}
+
+func newColorRamp(cs []color.Color, lo, hi, sea float32, hasSea bool) *colorRamp {
+	r := &colorRamp{lo: lo, hi: hi, sea: sea, last: len(cs) - 1}
+	if hasSea && sea > lo && sea < hi {
+		r.seaIdx = waterColorNum(cs)
+	}
+	return r
+}
+
+func (r *colorRamp) index(h float32) int {
+	if r.seaIdx <= 0 || r.seaIdx >= r.last {
+		return int(float32(r.last) * terrain.Level(h, r.lo, r.hi))
+	}
+	if h < r.sea {
+		ci := int(float32(r.seaIdx) * terrain.Level(h, r.lo, r.sea))
+		if ci >= r.seaIdx {
+			ci = r.seaIdx - 1
+		}
+		return ci
+	}
+	return r.seaIdx + int(float32(r.last-r.seaIdx)*terrain.Level(h, r.sea, r.hi))
+}
+
+// 模板底部连续的水色(蓝色分量最大)数量
+func waterColorNum(cs []color.Color) int {
+	n := 0
+	for _, c := range cs {
+		cr, cg, cb, _ := c.RGBA()
+		if cb <= cr || cb <= cg {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func lineTo(img *image.RGBA, startX, startY, destX, destY int, lineColor, startColor color.Color, scale float64) {
+	distM := math.Sqrt(float64((startX-destX)*(startX-destX) + (startY-destY)*(startY-destY)))
+	var i float64
+	for i = 0; i < distM*scale; i++ {
+		img.Set(startX+int(i/distM*float64(destX-startX)), startY+int(i/distM*float64(destY-startY)), lineColor)
+	}
+	// 线段最后一点 绘制成始发地地形的颜色 startColor
+	if startX != destX && startY != destY {
+		img.Set(startX+int(i/distM*float64(destX-startX)), startY+int(i/distM*float64(destY-startY)), startColor)
+	}
+}
+
+// 新建按opt.Zoom放大后的画布
+func NewImage(m *terrain.Topomap, opt *Options) *image.RGBA {
+	return image.NewRGBA(image.Rect(0, 0, m.Width*opt.Zoom, m.Height*opt.Zoom))
+}
+
+func DrawToImg(img *image.RGBA, m *terrain.Topomap, w *hydro.WaterMap, maxColor float32, drops []*hydro.Droplet, opt *Options) {
+	height := m.Height
+	width := m.Width
+	zoom := opt.Zoom
+
+	// 着色范围 允许负海拔时从最低点开始
+	st := m.Stats()
+	log.Printf("draw topomap: %s", st)
+	minColor := st.Min
+	if minColor > 0 {
+		minColor = 0
+	}
+
+	// 获取颜色模板
+	cs := ColorTpl(opt.ColorTplFile, opt.ColorTplStep)
+	cslen := len(cs) - 1
+	log.Printf("color-tpl has %d steps", cslen)
+	ramp := newColorRamp(cs, minColor, maxColor, m.SeaLevel, st.LandRatio < 1)
+	// 地图背景地形绘制
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var ctmp color.Color = cs[ramp.index(m.Data[x+y*width])]
+			if opt.Biomes != nil {
+				ctmp = opt.BiomePalette[opt.Biomes[x+y*width]]
+			}
+			// 放大
+			for zix := 0; zix < zoom; zix++ {
+				for ziy := 0; ziy < zoom; ziy++ {
+					img.Set(x*zoom+zix, y*zoom+ziy, ctmp)
+				}
+			}
+		}
+	}
+
+	// 绘制WaterMap
+	tmpLakeColor := color.RGBA{0, 0xa0, 0xE0, 0xFF}     // alpha=255 表示不透明 青色 有积水
+	tmpLakeColor2 := color.RGBA{0x40, 0x72, 0xcb, 0xFF} //#b0d2eb    // blue-gray // 流量痕迹
+	tmpLakeColor3 := color.RGBA{0x99, 0xFF, 0xFF, 0xFF} //#亮蓝色    // 水滴痕迹
+	tmpColor4 := color.RGBA{0x50, 0xd6, 0xFE, 0xFF}     //    // 青色 // 水滴最终位置
+	for _, dot := range w.Data {
+		// 绘制积水 点周围绘制
+		if dot.H > 0 {
+			img.Set(int(dot.X)*zoom+zoom/2+1, int(dot.Y)*zoom+zoom/2, tmpLakeColor)
+		}
+		if dot.Q > 0 && dot.Q >= opt.RiverQ {
+			img.Set(int(dot.X)*zoom+zoom/2+1, int(dot.Y)*zoom+zoom/2, tmpLakeColor2)
+		}
+	}
+
+	// 绘制流动 在v2下相当于场
+	if (opt.DrawFlag & DrawFlagField) > 0 {
+		for di, dot := range w.Data {
+			// 绘制当前点 如果是源头 则绘制白色
+			if dot.XPower != 0.0 || dot.YPower != 0.0 {
+				// 计算相对比例尺的高度
+				tmpLevel := ramp.index(m.Data[di] + float32(dot.H))
+
+				// 绘制流动方向 考虑缩放
+				tmpColor := cs[tmpLevel]
+				lineTo(img, int(dot.X)*zoom+zoom/2, int(dot.Y)*zoom+zoom/2, int(dot.X)*zoom+zoom/2+int(float32(zoom)*dot.XPower), int(dot.Y)*zoom+zoom/2+int(float32(zoom)*dot.YPower), color.RGBA{0, 0, 0xFF, 0xFF}, tmpColor, opt.RiverArrowScale)
+			}
+		}
+	}
+	// 绘制droplets
+	for _, drop := range drops {
+		if opt.DrawFlag&DrawFlagHisway > 0 {
+			for _, dxi := range drop.Hisway {
+				img.Set(int(dxi%width)*zoom+zoom/2, int(dxi/width)*zoom+zoom/2, tmpLakeColor3)
+			}
+		}
+		img.Set(int(drop.X)*zoom+zoom/2, int(drop.Y)*zoom+zoom/2, tmpColor4)
+	}
+	// 绘制图例 群系模式下每个群系一格
+	if opt.Biomes != nil {
+		for b, c := range opt.BiomePalette {
+			for wi := 0; wi < 5; wi++ {
+				for hi := 0; hi < 5; hi++ {
+					img.Set(wi, b*6+hi, c)
+				}
+			}
+		}
+		return
+	}
+	// 绘制颜色模板
+	for i := 0; i < len(cs); i++ {
+		c := cs[len(cs)-i-1]
+		for wi := 0; wi < 5; wi++ {
+			img.Set(wi, i, c)
+		}
+	}
+	// 加1条白色
+	for wi := 0; wi < 5; wi++ {
+		img.Set(wi, len(cs), color.White)
+	}
+}
+
+// 按Strahler等级绘制河网 等级越高河道越宽
+func DrawRivers(img *image.RGBA, rivers *hydro.RiverNetwork, opt *Options) {
+	riverColor := color.RGBA{0x40, 0x72, 0xcb, 0xFF}
+	zoom := float64(opt.Zoom)
+	widthStep := opt.RiverWidth
+	if widthStep <= 0 {
+		widthStep = 1
+	}
+	// 先画低等级 高等级覆盖在上面
+	for order := 1; order <= rivers.MaxStrahler(); order++ {
+		width := zoom * (1 + widthStep*float64(order-1)) / 2
+		for _, seg := range rivers.Segments {
+			if seg.Strahler != order {
+				continue
+			}
+			for pi := 1; pi < len(seg.Points); pi++ {
+				p0, p1 := seg.Points[pi-1], seg.Points[pi]
+				thickLineTo(img, float64(p0.X)*zoom, float64(p0.Y)*zoom, float64(p1.X)*zoom, float64(p1.Y)*zoom, width, riverColor)
+			}
+		}
+	}
+}
+
+// 浅水模拟的水深着色 水越深越不透明 忽略浅于minDepth的水膜
+func DrawWater(img *image.RGBA, sim *hydro.PipeSim, minDepth float32, opt *Options) {
+	water := color.RGBA{0x20, 0x60, 0xd0, 0xFF}
+	zoom := opt.Zoom
+	for idx, d := range sim.Water {
+		if d < minDepth || d <= 0 {
+			continue
+		}
+		alpha := math.Min(1, 0.3+float64(d))
+		x, y := idx%sim.Width, idx/sim.Width
+		for zix := 0; zix < zoom; zix++ {
+			for ziy := 0; ziy < zoom; ziy++ {
+				px, py := x*zoom+zix, y*zoom+ziy
+				img.Set(px, py, blend(img.RGBAAt(px, py), water, alpha))
+			}
+		}
+	}
+}
+
+// 湖面着色 越深颜色越深
+func DrawLakes(img *image.RGBA, lakes *hydro.LakeMap, opt *Options) {
+	shallow := color.RGBA{0x70, 0xc8, 0xf0, 0xFF}
+	deep := color.RGBA{0x10, 0x50, 0xa0, 0xFF}
+	zoom := opt.Zoom
+	for _, lake := range lakes.Lakes {
+		for _, idx := range lake.Cells {
+			t := 0.0
+			if lake.MaxDepth > 0 {
+				t = float64(lakes.Depth[idx] / lake.MaxDepth)
+			}
+			c := blend(shallow, deep, t)
+			x, y := idx%lakes.Width, idx/lakes.Width
+			for zix := 0; zix < zoom; zix++ {
+				for ziy := 0; ziy < zoom; ziy++ {
+					img.Set(x*zoom+zix, y*zoom+ziy, c)
+				}
+			}
+		}
+	}
+}
+
+// 流域半透明着色 流域边界加深 内流区用红色边界
+func DrawBasins(img *image.RGBA, basins *hydro.BasinMap, opt *Options) {
+	zoom := opt.Zoom
+	const alpha = 0.4
+	for y := 0; y < basins.Height; y++ {
+		for x := 0; x < basins.Width; x++ {
+			basin := basins.At(x, y)
+			if basin == nil || basin.Area < opt.BasinMinArea {
+				continue
+			}
+			c := basinColor(basin.ID)
+			// 与右边或下边的点不在同一流域即为边界
+			if right, down := basins.At(x+1, y), basins.At(x, y+1); (right != nil && right != basin) || (down != nil && down != basin) {
+				c = color.RGBA{0x30, 0x30, 0x30, 0xFF}
+				if basin.Endorheic {
+					c = color.RGBA{0xc0, 0x20, 0x20, 0xFF}
+				}
+			}
+			for zix := 0; zix < zoom; zix++ {
+				for ziy := 0; ziy < zoom; ziy++ {
+					px, py := x*zoom+zix, y*zoom+ziy
+					img.Set(px, py, blend(img.RGBAAt(px, py), c, alpha))
+				}
+			}
+		}
+	}
+}
+
+// 绘制积雪和冰川 积雪盖白色 冰川为淡蓝 冰流量越大越蓝
+func DrawSnow(img *image.RGBA, snow *climate.SnowCover, opt *Options) {
+	white := color.RGBA{0xfa, 0xfc, 0xff, 0xFF}
+	ice := color.RGBA{0xa8, 0xd8, 0xf0, 0xFF}
+	deep := color.RGBA{0x60, 0xa8, 0xd8, 0xFF}
+	zoom := opt.Zoom
+	for idx := range snow.Snow {
+		if !snow.Covered(idx) {
+			continue
+		}
+		c, alpha := white, 0.8
+		if snow.IsGlacier(idx) {
+			c, alpha = blend(ice, deep, math.Min(math.Sqrt(float64(snow.Ice[idx]))/10, 1)), 0.9
+		}
+		x, y := idx%snow.Width, idx/snow.Width
+		for zix := 0; zix < zoom; zix++ {
+			for ziy := 0; ziy < zoom; ziy++ {
+				px, py := x*zoom+zix, y*zoom+ziy
+				img.Set(px, py, blend(img.RGBAAt(px, py), c, alpha))
+			}
+		}
+	}
+}
+
+// 按ID取色 黄金角分布色相 相邻ID颜色差别大
+func basinColor(id int) color.RGBA {
+	hue := math.Mod(float64(id)*137.508, 360) / 60
+	x := uint8(255 * (1 - math.Abs(math.Mod(hue, 2)-1)))
+	switch int(hue) {
+	case 0:
+		return color.RGBA{255, x, 0, 0xFF}
+	case 1:
+		return color.RGBA{x, 255, 0, 0xFF}
+	case 2:
+		return color.RGBA{0, 255, x, 0xFF}
+	case 3:
+		return color.RGBA{0, x, 255, 0xFF}
+	case 4:
+		return color.RGBA{x, 0, 255, 0xFF}
+	default:
+		return color.RGBA{255, 0, x, 0xFF}
+	}
+}
+
+func blend(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(u, v uint8) uint8 { return uint8(float64(u)*(1-t) + float64(v)*t) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xFF}
+}
+
+// 沿线段每半个像素画一个圆点 得到宽度为2*radius的线
+func thickLineTo(img *image.RGBA, x0, y0, x1, y1, radius float64, c color.Color) {
+	dist := math.Hypot(x1-x0, y1-y0)
+	r := int(math.Ceil(radius))
+	for t := 0.0; t <= dist; t += 0.5 {
+		cx, cy := x0, y0
+		if dist > 0 {
+			cx, cy = x0+(x1-x0)*t/dist, y0+(y1-y0)*t/dist
+		}
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				if float64(dx*dx+dy*dy) <= radius*radius {
+					img.Set(int(cx)+dx, int(cy)+dy, c)
+				}
+			}
+		}
+	}
+}
+
+// 每个点输出一个字符 高度取整后对应str中的下标 海平面以下输出空格
+func DrawToConsole(m *terrain.Topomap) {
+	str := "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ~!@#$%^&*-=_+()[]{}<>\\/;:,.???????????????????????????????????????"
+	for di, dd := range m.Data {
+		ci := int(dd)
+		if ci < 0 {
+			ci = 0
+		}
+		if ci >= len(str) {
+			ci = len(str) - 1
+		}
+		if m.IsSea(di) {
+			fmt.Printf(" ")
+		} else {
+			fmt.Printf("%c", str[ci])
+		}
+		if di%m.Width == (m.Width - 1) {
+			fmt.Printf("\n")
+		}
+	}
+}
+
+// 沉积层灰度图 越白沉积越厚 按最厚处归一化
+func SedimentImage(m *terrain.Topomap) *image.Gray {
+	if m.Sediment == nil {
+		return image.NewGray(image.Rect(0, 0, m.Width, m.Height))
+	}
+	return GrayImage(m.Sediment, m.Width, m.Height)
+}
+
+// 数据层的灰度图 按最大值归一化 用于输出降水 湿度等
+func GrayImage(data []float32, width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	var max float32
+	for _, v := range data {
+		if v > max {
+			max = v
+		}
+	}
+	if max <= 0 {
+		return img
+	}
+	for idx, v := range data {
+		if v > 0 {
+			img.Pix[idx] = uint8(255 * v / max)
+		}
+	}
+	return img
+}
+
+// 气温着色的范围 低于下限为深蓝 高于上限为深红 0度为白色
+const (
+	TempColorMin = -30
+	TempColorMax = 40
+)
+
+// 气温图 按固定范围着色 不同地图的颜色可以比较
+func TemperatureImage(data []float32, width, height int) *image.RGBA {
+	cold, white, hot := color.RGBA{20, 40, 160, 255}, color.RGBA{255, 255, 255, 255}, color.RGBA{180, 20, 20, 255}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for idx, v := range data {
+		var c color.RGBA
+		if v < 0 {
+			c = blend(white, cold, math.Min(float64(v)/TempColorMin, 1))
+		} else {
+			c = blend(white, hot, math.Min(float64(v)/TempColorMax, 1))
+		}
+		img.Set(idx%width, idx/width, c)
+	}
+	return img
+}
+
+// 遮罩灰度图 true为白色
+func MaskImage(mask []bool, width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for idx, v := range mask {
+		if v {
+			img.Pix[idx] = 0xFF
+		}
+	}
+	return img
+}
+
+func ImgToFile(outputFilePath string, img image.Image, format string) {
+	picFile2, err := os.Create(outputFilePath)
+	if err != nil {
+		log.Printf("when create file %s error:%v", outputFilePath, err)
+		return
+	}
+	defer picFile2.Close()
+	if err := png.Encode(picFile2, img); err != nil {
+		log.Println("png.Encode error:", err)
+	}
+}
@@ -0,0 +1,262 @@
+package terrain
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"math"
+	"math/rand"
+	"os"
+)
+
+// 大陆遮罩配置 遮罩为1处保留地形并抬升 为0处沉入海底 保证地图四周是海
+type ContinentConfig struct {
+	Type     string  // radial|multi|archipelago|png 为空不启用
+	Num      int     // multi/archipelago 的大陆或岛屿数
+	Radius   float64 // 大陆半径 占地图短边一半的比例 默认radial=0.8 multi=0.5 archipelago=0.15
+	Falloff  float64 // 海岸过渡带宽度 占半径的比例 默认0.3
+	Noise    float64 // 海岸线扰动幅度 0-1 默认0.3
+	File     string  // png遮罩文件 灰度值越大越是陆地
+	Lift     float64 // 陆地整体抬升的高度 让平原露出海面
+	SeaLevel float64 // 海平面 截断为0时不设置默认为1 不能低于seaMargin 否则截断后海底会高出海平面
+	Coast    CoastConfig
+}
+
+// 海岸线后处理
+type CoastConfig struct {
+	Smooth    int // 平滑海岸线的次数
+	MinIsland int // 面积小于此值的岛屿沉入海中
+	MinLake   int // 面积小于此值且不与外海相连的积水区填平
+}
+
+// 沉入海中的点低于海平面的量
+const seaMargin = 0.01
+
+// 大陆中心和半径 单位像素
+type continentSeed struct {
+	x, y, r float64
+}
+
+// 生成大陆遮罩 取值[0,1] 除png外地图边缘为0
+func (c ContinentConfig) Mask(rnd *rand.Rand, width, height int) ([]float32, error) {
+	if c.Type == "png" {
+		return LoadGrayPng(c.File, width, height)
+	}
+	mask := make([]float32, width*height)
+
+	half := math.Min(float64(width), float64(height)) / 2
+	falloff, noiseAmp := c.Falloff, c.Noise
+	if falloff <= 0 {
+		falloff = 0.3
+	}
+	if noiseAmp <= 0 {
+		noiseAmp = 0.3
+	}
+
+	var seeds []continentSeed
+	switch c.Type {
+	case "radial":
+		radius := c.Radius
+		if radius <= 0 {
+			radius = 0.8
+		}
+		seeds = []continentSeed{{float64(width) / 2, float64(height) / 2, radius * half}}
+	case "multi", "archipelago":
+		num, radius := c.Num, c.Radius
+		if num <= 0 {
+			num = 3
+			if c.Type == "archipelago" {
+				num = 20
+			}
+		}
+		if radius <= 0 {
+			radius = 0.5
+			if c.Type == "archipelago" {
+				radius = 0.15
+			}
+		}
+		for i := 0; i < num; i++ {
+			r := radius * half * (0.6 + rnd.Float64()*0.4)
+			// 中心离边缘至少一个半径
+			seeds = append(seeds, continentSeed{
+				x: r + rnd.Float64()*math.Max(float64(width)-2*r, 1),
+				y: r + rnd.Float64()*math.Max(float64(height)-2*r, 1),
+				r: r,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown continent type: %s", c.Type)
+	}
+
+	coast := &FBM{Noise: newPerlin(rnd).Noise, Scale: half / 3, Octaves: 5, Persistence: 0.5, Lacunarity: 2}
+	border := half / 10
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// 海岸线扰动 改变到中心的有效距离
+			wobble := 1 + noiseAmp*(coast.Height(x, y)*2-1)
+			var v float64
+			for _, s := range seeds {
+				d := math.Hypot(float64(x)-s.x, float64(y)-s.y) * wobble / s.r
+				v = math.Max(v, smoothstep((1-d)/falloff))
+			}
+			// 靠近地图边框强制为海
+			edge := math.Min(math.Min(float64(x), float64(width-1-x)), math.Min(float64(y), float64(height-1-y)))
+			v *= smoothstep(edge / border)
+			mask[x+y*width] = float32(v)
+		}
+	}
+	return mask, nil
+}
+
+func smoothstep(t float64) float64 {
+	t = clamp01(t)
+	return t * t * (3 - 2*t)
+}
+
+// 读取png灰度图 按最近邻缩放到地图尺寸 取值[0,1]
+func LoadGrayPng(file string, width, height int) ([]float32, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]float32, width*height)
+	b := img.Bounds()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px := b.Min.X + x*b.Dx()/width
+			py := b.Min.Y + y*b.Dy()/height
+			g := color.GrayModel.Convert(img.At(px, py)).(color.Gray)
+			mask[x+y*width] = float32(g.Y) / 255
+		}
+	}
+	return mask, nil
+}
+
+// 应用大陆遮罩 h=(h+lift)*mask 设置海平面 然后处理海岸线
+// 遮罩为0处和地图边框压到海平面以下 海平面为0或png遮罩边缘不为0时四周也是海
+func (c ContinentConfig) Apply(rnd *rand.Rand, m *Topomap) error {
+	if c.Type == "" {
+		return nil
+	}
+	if m.Clamp == ClampFloor {
+		if c.SeaLevel == 0 {
+			c.SeaLevel = 1
+		} else if c.SeaLevel < seaMargin {
+			return fmt.Errorf("sea level %v too low for floor clamp, need at least %v", c.SeaLevel, seaMargin)
+		}
+	}
+	mask, err := c.Mask(rnd, m.Width, m.Height)
+	if err != nil {
+		return err
+	}
+	var sea []int
+	for i := range m.Data {
+		m.Data[i] = m.clamp((m.Data[i] + float32(c.Lift)) * mask[i])
+		x, y := i%m.Width, i/m.Width
+		if mask[i] <= 0 || x == 0 || y == 0 || x == m.Width-1 || y == m.Height-1 {
+			sea = append(sea, i)
+		}
+	}
+	m.SeaLevel = float32(c.SeaLevel)
+	m.setSea(sea, true)
+	m.ProcessCoast(c.Coast)
+	return nil
+}
+
+// 海岸线后处理 平滑 去掉小岛 填平小水洼
+func (m *Topomap) ProcessCoast(c CoastConfig) {
+	for i := 0; i < c.Smooth; i++ {
+		m.smoothCoast()
+	}
+	if c.MinIsland > 0 {
+		m.eachRegion(false, func(cells []int, touchBorder bool) {
+			if len(cells) < c.MinIsland {
+				m.setSea(cells, true)
+			}
+		})
+	}
+	if c.MinLake > 0 {
+		m.eachRegion(true, func(cells []int, touchBorder bool) {
+			if !touchBorder && len(cells) < c.MinLake {
+				m.setSea(cells, false)
+			}
+		})
+	}
+}
+
+// 把cells的高度移到海平面以下或以上
+func (m *Topomap) setSea(cells []int, sea bool) {
+	for _, idx := range cells {
+		if sea && m.Data[idx] >= m.SeaLevel {
+			m.Data[idx] = m.SeaLevel - seaMargin
+		} else if !sea && m.Data[idx] < m.SeaLevel {
+			m.Data[idx] = m.SeaLevel + seaMargin
+		}
+	}
+}
+
+// 多数投票平滑海岸线 周围8格中陆地多于5格的海变为陆地 少于3格的陆地变为海
+func (m *Topomap) smoothCoast() {
+	flip := make([]int8, len(m.Data))
+	for y := 1; y < m.Height-1; y++ {
+		for x := 1; x < m.Width-1; x++ {
+			land := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dx != 0 || dy != 0) && !m.IsSea(x+dx+(y+dy)*m.Width) {
+						land++
+					}
+				}
+			}
+			idx := x + y*m.Width
+			if m.IsSea(idx) && land > 5 {
+				flip[idx] = 1
+			} else if !m.IsSea(idx) && land < 3 {
+				flip[idx] = -1
+			}
+		}
+	}
+	for idx, f := range flip {
+		if f != 0 {
+			m.setSea([]int{idx}, f < 0)
+		}
+	}
+}
+
+// 遍历所有海(sea=true)或陆地的4连通区域 touchBorder表示区域是否接触地图边缘
+func (m *Topomap) eachRegion(sea bool, fn func(cells []int, touchBorder bool)) {
+	visited := make([]bool, len(m.Data))
+	for start := range m.Data {
+		if visited[start] || m.IsSea(start) != sea {
+			continue
+		}
+		visited[start] = true
+		cells := []int{start}
+		touchBorder := false
+		for qi := 0; qi < len(cells); qi++ {
+			idx := cells[qi]
+			x, y := idx%m.Width, idx/m.Width
+			if x == 0 || y == 0 || x == m.Width-1 || y == m.Height-1 {
+				touchBorder = true
+			}
+			for _, d := range [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+				nx, ny := x+d[0], y+d[1]
+				if nx < 0 || ny < 0 || nx >= m.Width || ny >= m.Height {
+					continue
+				}
+				ni := nx + ny*m.Width
+				if !visited[ni] && m.IsSea(ni) == sea {
+					visited[ni] = true
+					cells = append(cells, ni)
+				}
+			}
+		}
+		fn(cells, touchBorder)
+	}
+}
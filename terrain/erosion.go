@@ -0,0 +1,168 @@
+package terrain
+
+import (
+	"math"
+	"math/rand"
+)
+
+// 粒子水力侵蚀参数 水滴携带泥沙 按速度 坡度 水量决定携沙能力
+// 能力有余时按刷子半径侵蚀 能力不足或上坡时沉积 水量逐步蒸发
+type HydraulicErosion struct {
+	Droplets    int     // 水滴数 0表示不侵蚀
+	Lifetime    int     // 每个水滴最多移动的步数 默认30
+	Inertia     float64 // 惯性 0-1 越大越保持原方向 默认0.05
+	Capacity    float64 // 携沙能力系数 默认4
+	MinCapacity float64 // 平地上的最小携沙能力 默认0.01
+	Deposition  float64 // 沉积速率 0-1 默认0.3
+	Erosion     float64 // 侵蚀速率 0-1 默认0.3
+	Evaporation float64 // 每步蒸发比例 0-1 默认0.01
+	Gravity     float64 // 重力 决定下坡加速 默认4
+	Radius      int     // 侵蚀刷子半径 默认3
+	InitSpeed   float64 // 初速度 默认1
+	InitWater   float64 // 初始水量 默认1
+}
+
+func (c *HydraulicErosion) setDefaults() {
+	defaults := []struct {
+		v   *float64
+		def float64
+	}{
+		{&c.Inertia, 0.05}, {&c.Capacity, 4}, {&c.MinCapacity, 0.01}, {&c.Deposition, 0.3},
+		{&c.Erosion, 0.3}, {&c.Evaporation, 0.01}, {&c.Gravity, 4}, {&c.InitSpeed, 1}, {&c.InitWater, 1},
+	}
+	for _, d := range defaults {
+		if *d.v <= 0 {
+			*d.v = d.def
+		}
+	}
+	if c.Lifetime <= 0 {
+		c.Lifetime = 30
+	}
+	if c.Radius <= 0 {
+		c.Radius = 3
+	}
+}
+
+// 刷子内一个点相对中心的偏移和权重
+type brushCell struct {
+	dx, dy int
+	w      float32
+}
+
+// 权重随距离线性衰减 总和为1
+func makeBrush(radius int) []brushCell {
+	var brush []brushCell
+	var sum float32
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if d := math.Hypot(float64(dx), float64(dy)); d < float64(radius) {
+				w := float32(float64(radius) - d)
+				brush = append(brush, brushCell{dx, dy, w})
+				sum += w
+			}
+		}
+	}
+	for i := range brush {
+		brush[i].w /= sum
+	}
+	return brush
+}
+
+// 依次模拟c.Droplets个水滴 水滴按顺序执行 同一个rnd得到同样的结果
+// 返回被侵蚀和沉积的总量
+func (m *Topomap) Erode(rnd *rand.Rand, c HydraulicErosion) (eroded, deposited float32) {
+	if c.Droplets <= 0 || m.Width < 2 || m.Height < 2 {
+		return 0, 0
+	}
+	c.setDefaults()
+	brush := makeBrush(c.Radius)
+
+	for i := 0; i < c.Droplets; i++ {
+		x := rnd.Float64() * float64(m.Width-1)
+		y := rnd.Float64() * float64(m.Height-1)
+		var dirX, dirY, sediment float64
+		speed, water := c.InitSpeed, c.InitWater
+
+		for step := 0; step < c.Lifetime; step++ {
+			cx, cy := int(x), int(y)
+			h, gx, gy := m.gradient(x, y)
+
+			// 方向在惯性和坡度之间插值
+			dirX = dirX*c.Inertia - gx*(1-c.Inertia)
+			dirY = dirY*c.Inertia - gy*(1-c.Inertia)
+			l := math.Hypot(dirX, dirY)
+			if l == 0 {
+				break
+			}
+			dirX, dirY = dirX/l, dirY/l
+			nx, ny := x+dirX, y+dirY
+			if nx < 0 || ny < 0 || nx >= float64(m.Width-1) || ny >= float64(m.Height-1) {
+				break
+			}
+
+			nh, _, _ := m.gradient(nx, ny)
+			deltaH := nh - h
+			capacity := math.Max(-deltaH*speed*water*c.Capacity, c.MinCapacity)
+
+			if sediment > capacity || deltaH > 0 {
+				// 上坡时填平身后的坑 否则沉积超出能力的部分
+				amount := (sediment - capacity) * c.Deposition
+				if deltaH > 0 {
+					amount = math.Min(deltaH, sediment)
+				}
+				sediment -= amount
+				m.deposit(cx, cy, x-float64(cx), y-float64(cy), float32(amount))
+				deposited += float32(amount)
+			} else {
+				// 侵蚀不超过高度差 避免挖出坑
+				amount := math.Min((capacity-sediment)*c.Erosion, -deltaH)
+				removed := m.erodeBrush(cx, cy, brush, float32(amount))
+				sediment += float64(removed)
+				eroded += removed
+			}
+
+			speed = math.Sqrt(math.Max(0, speed*speed-deltaH*c.Gravity))
+			water *= 1 - c.Evaporation
+			x, y = nx, ny
+		}
+	}
+	return eroded, deposited
+}
+
+// (x,y)处双线性插值的高度和梯度 要求x<Width-1 y<Height-1
+func (m *Topomap) gradient(x, y float64) (h, gx, gy float64) {
+	cx, cy := int(x), int(y)
+	u, v := x-float64(cx), y-float64(cy)
+	idx := cx + cy*m.Width
+	nw, ne := float64(m.Data[idx]), float64(m.Data[idx+1])
+	sw, se := float64(m.Data[idx+m.Width]), float64(m.Data[idx+m.Width+1])
+	gx = (ne-nw)*(1-v) + (se-sw)*v
+	gy = (sw-nw)*(1-u) + (se-ne)*u
+	h = nw*(1-u)*(1-v) + ne*u*(1-v) + sw*(1-u)*v + se*u*v
+	return
+}
+
+// 按双线性权重沉积到(cx,cy)格子的4个角
+func (m *Topomap) deposit(cx, cy int, u, v float64, amount float32) {
+	idx := cx + cy*m.Width
+	m.Deposit(idx, amount*float32((1-u)*(1-v)))
+	m.Deposit(idx+1, amount*float32(u*(1-v)))
+	m.Deposit(idx+m.Width, amount*float32((1-u)*v))
+	m.Deposit(idx+m.Width+1, amount*float32(u*v))
+}
+
+// 按刷子权重侵蚀(cx,cy)周围 返回实际侵蚀量 ClampFloor时不会低于0
+func (m *Topomap) erodeBrush(cx, cy int, brush []brushCell, amount float32) float32 {
+	var removed float32
+	for _, b := range brush {
+		x, y := cx+b.dx, cy+b.dy
+		if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+			continue
+		}
+		idx := x + y*m.Width
+		old := m.Data[idx]
+		m.Lower(idx, amount*b.w)
+		removed += old - m.Data[idx]
+	}
+	return removed
+}
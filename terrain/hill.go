@@ -0,0 +1,229 @@
+package terrain
+
+import (
+	"math"
+	"math/rand"
+)
+
+type Hill struct {
+	X       int
+	Y       int
+	Rad     int
+	H       int
+	TiltDir float64 // 倾斜方向 峰顶向这个方向偏移 这一面是陡坡 同时也是花瓣的朝向
+	TiltLen int     // 倾斜长度 峰顶偏离中心的距离 0为对称
+	Profile Profile // 海拔剖面
+}
+
+// 倾斜参数 峰顶偏向陡坡一侧 背面是缓坡 形成单面山/断崖
+type TiltFlag struct {
+	Ratio  float64 // 峰顶偏离中心的距离占半径的比例 [0,1) 0为不倾斜
+	Dir    float64 // 陡坡朝向 单位度 0=x正方向 90=y正方向
+	Spread float64 // 朝向的随机偏差 单位度 360为完全随机
+}
+
+// 给hills设置倾斜 Ratio为0时不改变hills
+func (t TiltFlag) Apply(rnd *rand.Rand, hills []Hill) {
+	if t.Ratio <= 0 {
+		return
+	}
+	ratio := math.Min(t.Ratio, 0.95)
+	for i := range hills {
+		hills[i].TiltDir = (t.Dir + (rnd.Float64()-0.5)*t.Spread) * math.Pi / 180
+		hills[i].TiltLen = int(ratio * float64(hills[i].Rad))
+	}
+}
+
+// 点(x,y)处的海拔 distM是到中心距离的平方 rn是该方向上的边界半径
+func (h *Hill) heightAt(x, y, distM, rn int) float32 {
+	if h.TiltLen <= 0 && h.Profile == ProfileQuartic {
+		return float32(h.H) - float32(float64(h.H)*math.Sqrt(math.Sqrt(float64(distM)/float64((rn*rn)))))
+	}
+	var t float64
+	if h.TiltLen > 0 {
+		t = h.tiltDist(x, y, float64(rn))
+	} else {
+		t = math.Sqrt(float64(distM)) / float64(rn)
+	}
+	return float32(float64(h.H) * h.Profile.Height(t))
+}
+
+// 倾斜后 点(x,y)到峰顶的距离与峰顶沿同方向到边界距离的比值 [0,1]
+// 峰顶偏向TiltDir 这一侧到边界近 坡陡 反方向远 坡缓
+func (h *Hill) tiltDist(x, y int, rn float64) float64 {
+	offset := math.Min(float64(h.TiltLen), rn*0.95)
+	px, py := float64(h.X)+offset*math.Cos(h.TiltDir), float64(h.Y)+offset*math.Sin(h.TiltDir)
+	dx, dy := float64(x)-px, float64(y)-py
+	dist := math.Sqrt(dx*dx + dy*dy)
+	if dist == 0 {
+		return 0
+	}
+	ux, uy := dx/dist, dy/dist
+	// 从峰顶沿u方向到半径rn圆周的距离 解 |p-c+s*u| = rn
+	ox, oy := px-float64(h.X), py-float64(h.Y)
+	b := ox*ux + oy*uy
+	edge := -b + math.Sqrt(b*b-(ox*ox+oy*oy-rn*rn))
+	if edge <= 0 {
+		return 1
+	}
+	return math.Min(dist/edge, 1)
+}
+
+type PetalFlag struct {
+	Shape    int     // 形状
+	PetalNum float64 // 花瓣数
+	Sharp    float64 // 锋利度
+}
+
+// get radius 一个点(x,y)看hill的边距离 hill是三角形 不同视角看到的距离不一样 返回的R不能比hill.r大
+// 返回花瓣状距离 花瓣hill产生的高原效果特别好
+// todo: 有模糊横线 精度损失导致
+func (h *Hill) R(x, y int, petalFlag *PetalFlag) (r int) {
+
+	switch petalFlag.Shape {
+	case 1:
+		// 尖角瓣状 需要加大hill-wide 否则都是细线  1-abs(sin(dir))
+		diffDir := math.Atan2(float64(y-h.Y), float64(x-h.X)) - h.TiltDir // 找到方向差
+		dist := 1.0 - math.Abs(math.Sin(diffDir*petalFlag.PetalNum/2.0))*petalFlag.Sharp
+		return int(dist * float64(h.Rad))
+	case 2:
+		// 圆花瓣状 细腰长叶花瓣 1-sin(dir)
+		diffDir := math.Atan2(float64(y-h.Y), float64(x-h.X)) - h.TiltDir // 找到方向差
+		dist := 1.0 - (math.Sin(diffDir*petalFlag.PetalNum)+1.0)/2.0*petalFlag.Sharp
+		return int(dist * float64(h.Rad))
+	case 3:
+		// 圆形瓣状 圆润丰满 1-abs(sin(dir))
+		diffDir := math.Atan2(float64(y-h.Y), float64(x-h.X)) - h.TiltDir // 找到方向差
+		dist := 1.0 - (1.0-math.Abs(math.Sin(diffDir*petalFlag.PetalNum/2.0)))*petalFlag.Sharp
+		return int(dist * float64(h.Rad))
+	default:
+		//原型 相同hill-wide，比其他2种占用面积大
+		r = h.Rad
+	}
+	return
+}
+
+// 随机n个圆圈 累加抬高
+func MakeHills(rnd *rand.Rand, width, height, hillWide, num, maxHigh int) []Hill {
+	widthEdge := width / 9
+	heightEdge := height / 9
+	hills := make([]Hill, num)
+	for ri := range hills {
+		r := &hills[ri]
+		// todo 地图边框附近不要去
+		r.X, r.Y, r.H = (rnd.Int()%(width-widthEdge*2))+widthEdge, (rnd.Int()%(height-heightEdge*2))+heightEdge, rnd.Int()%maxHigh+maxHigh/2
+		// 倾斜方向 决定花瓣朝向 倾斜长度由TiltFlag设置
+		r.TiltDir, r.Rad = rnd.Float64()*math.Pi*2, int(math.Sqrt(float64(rnd.Int()%(hillWide*hillWide+1))))
+		if ri%3 == 1 {
+			// 1/3 是反向海拔，成为盆地
+			r.H *= -1
+		}
+	}
+
+	return hills
+}
+
+// ridgeLen=count(Hill)
+/**
+ridge = []Hill
+ridgeLen = Hill 个数
+ridgeWide = Hill wide
+*/
+func MakeRidge(rnd *rand.Rand, ridgeLen, ridgeWide, mWidth, mHeight, maxHigh int) []Hill {
+	ridgeHills := make([]Hill, ridgeLen)
+	widthEdge := mWidth / 8
+	heightEdge := mHeight / 8
+	// toward as step
+	baseTowardX1, baseTowardY1 := randomDir(rnd)
+	baseTowardX, baseTowardY := int(baseTowardX1*float32(ridgeWide)), int(baseTowardY1*float32(ridgeWide))
+	for ri := 0; ri < int(ridgeLen); ri++ {
+		r := &ridgeHills[ri]
+		r.H = rnd.Int()%maxHigh + maxHigh/2
+		r.TiltDir = rnd.Float64() * math.Pi * 2
+		if ri == 0 {
+			// 第一个
+			r.X, r.Y, r.Rad = (rnd.Int()%(mWidth-widthEdge*2))+widthEdge, (rnd.Int()%(mHeight-heightEdge*2))+heightEdge, (rnd.Int()%ridgeWide)/2+ridgeWide/2
+		} else {
+			// 其他 基础方向: ridgeHills[ri-1].x+baseTowardX 摆动:(rnd.Int()%ridgeWide)/2-(rnd.Int()%ridgeWide)/2
+			waveX, waveY := 0, 0
+			if baseTowardX != 0 {
+				waveY = (rnd.Int() % baseTowardX) - (rnd.Int() % baseTowardX)
+			}
+			if baseTowardY != 0 {
+				waveX = (rnd.Int() % baseTowardY) - (rnd.Int() % baseTowardY)
+			}
+			r.X, r.Y, r.Rad = ridgeHills[ri-1].X+baseTowardX/2+waveX, ridgeHills[ri-1].Y+baseTowardY/2+waveY, (rnd.Int()%ridgeWide)/2+ridgeWide/2
+		}
+	}
+
+	return ridgeHills
+}
+
+// 支脉参数 Depth为0时不分叉
+type BranchFlag struct {
+	Prob  float64 // 主脊每前进一个hill分出支脉的几率
+	Angle float64 // 支脉偏离主脊的角度 单位度
+	Depth int     // 最多递归几层支脉
+	Decay float64 // 每深一层 长度 宽度 高度的衰减比例
+}
+
+// 树枝型ridge 从(startX,startY)沿dir方向生成主脊 沿途按几率分出支脉
+// 支脉的长度 宽度 高度按Decay递减 支脉上还可以再分叉 直到Depth层
+func MakeRidge2(rnd *rand.Rand, startX, startY int, dir float64, ridgeLen, ridgeWide, maxHigh int, branch BranchFlag) []Hill {
+	if ridgeLen <= 0 || ridgeWide <= 0 || maxHigh <= 0 {
+		return nil
+	}
+	ridgeHills := make([]Hill, 0, ridgeLen)
+	x, y := float64(startX), float64(startY)
+	step := float64(ridgeWide) / 2
+	for ri := 0; ri < ridgeLen; ri++ {
+		r := Hill{X: int(x), Y: int(y), Rad: (rnd.Int()%ridgeWide)/2 + ridgeWide/2, H: rnd.Int()%maxHigh + maxHigh/2}
+		r.TiltDir = rnd.Float64() * math.Pi * 2
+		ridgeHills = append(ridgeHills, r)
+
+		// 分出支脉 向左或向右
+		if branch.Depth > 0 && ri > 0 && rnd.Float64() < branch.Prob {
+			side := float64(rnd.Int()%2*2 - 1)
+			subDir := dir + side*(branch.Angle*math.Pi/180)*(0.75+rnd.Float64()/2)
+			sub := branch
+			sub.Depth--
+			ridgeHills = append(ridgeHills, MakeRidge2(rnd, r.X, r.Y, subDir,
+				int(float64(ridgeLen-ri)*branch.Decay), int(float64(ridgeWide)*branch.Decay), int(float64(maxHigh)*branch.Decay), sub)...)
+		}
+
+		// 沿主方向前进 方向和垂直方向都有摆动
+		dir += (rnd.Float64() - 0.5) * 0.4
+		wave := (rnd.Float64() - 0.5) * step
+		x += math.Cos(dir)*step - math.Sin(dir)*wave
+		y += math.Sin(dir)*step + math.Cos(dir)*wave
+	}
+
+	return ridgeHills
+}
+
+// 用stuck压低或者抬高范围内的hill 偶数stuck把高度除以sinkDiv 奇数stuck加2 返回受影响的hill数
+// todo: do not accumulate calculate stucks
+func ApplyStucks(stucks []Hill, petalFlag *PetalFlag, hills []Hill, sinkDiv int) (stuckedCnt int) {
+	for sti := range stucks {
+		for hi := 0; hi < len(hills); hi++ {
+			distM := (hills[hi].X-stucks[sti].X)*(hills[hi].X-stucks[sti].X) + (hills[hi].Y-stucks[sti].Y)*(hills[hi].Y-stucks[sti].Y)
+			stuckR := stucks[sti].R(hills[hi].X, hills[hi].Y, petalFlag)
+			if distM < stuckR*stuckR {
+				stuckedCnt++
+				if sti%2 == 0 {
+					hills[hi].H /= sinkDiv
+				} else {
+					hills[hi].H += 2
+				}
+			}
+		}
+	}
+	return stuckedCnt
+}
+
+// 返回随机方向 x,y 取值范围 [-1,1]
+func randomDir(rnd *rand.Rand) (x, y float32) {
+	thedir := rnd.Float64() * math.Pi * 2
+	x, y = float32(math.Cos(thedir)), float32(math.Sin(thedir))
+	return
+}
@@ -0,0 +1,139 @@
+package terrain
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+
+	"gopkg.in/yaml.v2"
+)
+
+type HillGroup struct {
+	List []struct {
+		Num  int // num
+		Wide int // each wide
+		Len  int // each len
+		High int // each max high
+		// ridge的支脉 depth>0时生成树枝型ridge
+		Branch BranchFlag
+		// 倾斜 ratio>0时峰顶偏移 一面陡一面缓
+		Tilt TiltFlag
+		// 海拔剖面 quartic|cone|gaussian|cosine|mesa|crater|terrace 默认quartic
+		Profile string
+	}
+	PetalFlag
+}
+
+// 噪声底图 按顺序叠加 在hill之前生成
+type NoiseGroup struct {
+	List []NoiseConfig
+}
+
+// 多级组配置 最终组成hill 此版本都用此配置
+type LayoutConfig struct {
+	NoiseGroup NoiseGroup
+	RidgeGroup HillGroup
+	StuckGroup HillGroup
+	HillGroup  HillGroup
+	// 大陆遮罩和海平面 在hill填充之后应用
+	Continent ContinentConfig
+	// 粒子水力侵蚀 在大陆遮罩之后应用
+	Erosion HydraulicErosion
+	// 热力侵蚀 在水力侵蚀之前或之后应用
+	Thermal ThermalErosion
+}
+
+// 从yaml文件读取布局配置
+func LoadLayout(layoutYamlFile string) (*LayoutConfig, error) {
+	layoutContent, err := ioutil.ReadFile(layoutYamlFile)
+	if err != nil {
+		return nil, err
+	}
+
+	layoutConf := &LayoutConfig{}
+	if err = yaml.Unmarshal(layoutContent, layoutConf); err != nil {
+		return nil, err
+	}
+	if err = layoutConf.Validate(); err != nil {
+		return nil, err
+	}
+	return layoutConf, nil
+}
+
+// 检查配置中的名字类参数
+func (c *LayoutConfig) Validate() error {
+	for _, g := range []HillGroup{c.RidgeGroup, c.StuckGroup, c.HillGroup} {
+		for i := range g.List {
+			if _, err := ParseProfile(g.List[i].Profile); err != nil {
+				return err
+			}
+		}
+	}
+	return c.Thermal.Validate()
+}
+
+func (h HillGroup) ToHills(rnd *rand.Rand, width, height int) []Hill {
+	hills := make([]Hill, 0)
+	for i := range h.List {
+		if h.List[i].High <= 0 {
+			h.List[i].High = HillHeightMedian
+		}
+		groupHills := MakeHills(rnd, width, height, h.List[i].Wide, h.List[i].Num, h.List[i].High)
+		h.List[i].Tilt.Apply(rnd, groupHills)
+		setProfile(groupHills, h.List[i].Profile)
+		hills = append(hills, groupHills...)
+	}
+
+	return hills
+}
+
+func (h HillGroup) ToRidgeHills(rnd *rand.Rand, width, height int) []Hill {
+	hills := make([]Hill, 0)
+
+	for i := range h.List {
+		for li := 0; li < h.List[i].Num; li++ {
+			if h.List[i].High <= 0 {
+				h.List[i].High = HillHeightMedian
+			}
+			var ridge []Hill
+			if h.List[i].Branch.Depth > 0 {
+				ridge = makeBranchRidge(rnd, h.List[i].Len, h.List[i].Wide, width, height, h.List[i].High, h.List[i].Branch)
+			} else {
+				ridge = MakeRidge(rnd, h.List[i].Len, h.List[i].Wide, width, height, h.List[i].High)
+			}
+			h.List[i].Tilt.Apply(rnd, ridge)
+			setProfile(ridge, h.List[i].Profile)
+			hills = append(hills, ridge...)
+		}
+	}
+
+	return hills
+}
+
+// 按顺序把各噪声层叠加到m上
+func (n NoiseGroup) Apply(rnd *rand.Rand, m *Topomap) error {
+	for i := range n.List {
+		g, err := NewGenerator(rnd, n.List[i], m.Width, m.Height)
+		if err != nil {
+			return err
+		}
+		m.AddGenerator(g, float32(n.List[i].Base), float32(n.List[i].High), n.List[i].Mode)
+	}
+	return nil
+}
+
+// 和MakeRidge一样避开地图边缘 随机起点和方向 生成树枝型ridge
+func makeBranchRidge(rnd *rand.Rand, ridgeLen, ridgeWide, mWidth, mHeight, maxHigh int, branch BranchFlag) []Hill {
+	widthEdge := mWidth / 8
+	heightEdge := mHeight / 8
+	startX, startY := (rnd.Int()%(mWidth-widthEdge*2))+widthEdge, (rnd.Int()%(mHeight-heightEdge*2))+heightEdge
+	return MakeRidge2(rnd, startX, startY, rnd.Float64()*math.Pi*2, ridgeLen, ridgeWide, maxHigh, branch)
+}
+
+// 未知的剖面名在Validate中报错 这里按默认处理
+func setProfile(hills []Hill, name string) {
+	profile, _ := ParseProfile(name)
+	for i := range hills {
+		hills[i].Profile = profile
+	}
+}
@@ -0,0 +1,333 @@
+package terrain
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// 地形生成器 返回(x,y)处的高度 取值范围[0,1]
+// 自定义的生成器实现此接口后可以用AddGenerator叠加到Topomap上
+type Generator interface {
+	Height(x, y int) float64
+}
+
+// 生成器叠加到已有高度上的方式
+const (
+	NoiseModeAdd = "add" // 累加 默认
+	NoiseModeMax = "max" // 取较高者
+	NoiseModeMul = "mul" // 相乘 可作为遮罩
+)
+
+// 将g产生的高度 base+high*g.Height(x,y) 按mode叠加到m上 按行带并行
+func (m *Topomap) AddGenerator(g Generator, base, high float32, mode string) {
+	m.EachRowBand(func(_, y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < m.Width; x++ {
+				idx := x + y*m.Width
+				h := base + high*float32(g.Height(x, y))
+				switch mode {
+				case NoiseModeMax:
+					if h > m.Data[idx] {
+						m.Data[idx] = h
+					}
+				case NoiseModeMul:
+					m.Data[idx] *= h
+				default:
+					m.Data[idx] += h
+				}
+				m.Data[idx] = m.clamp(m.Data[idx])
+			}
+		}
+	})
+}
+
+// 一个噪声层的配置
+type NoiseConfig struct {
+	Type        string  // fbm|simplex|ridged|diamond|worley
+	Mode        string  // add|max|mul 叠加方式
+	Base        float64 // 高度偏移 可以为负
+	High        float64 // 振幅 高度=base+high*noise
+	Scale       float64 // 特征尺寸 单位像素 越大越平缓
+	Octaves     int     // 叠加的倍频数
+	Persistence float64 // 每个倍频的振幅衰减 diamond中为粗糙度
+	Lacunarity  float64 // 每个倍频的频率倍增
+	Offset      float64 // ridged的偏移
+	Gain        float64 // ridged的增益
+}
+
+// 由配置生成对应的生成器 未指定的参数取默认值
+func NewGenerator(rnd *rand.Rand, c NoiseConfig, width, height int) (Generator, error) {
+	if c.Scale <= 0 {
+		c.Scale = 128
+	}
+	if c.Octaves <= 0 {
+		c.Octaves = 6
+	}
+	if c.Persistence <= 0 {
+		c.Persistence = 0.5
+	}
+	if c.Lacunarity <= 0 {
+		c.Lacunarity = 2
+	}
+	if c.Offset <= 0 {
+		c.Offset = 1
+	}
+	if c.Gain <= 0 {
+		c.Gain = 2
+	}
+
+	switch c.Type {
+	case "fbm", "perlin":
+		p := newPerlin(rnd)
+		return &FBM{Noise: p.Noise, Scale: c.Scale, Octaves: c.Octaves, Persistence: c.Persistence, Lacunarity: c.Lacunarity}, nil
+	case "simplex":
+		p := newPerlin(rnd)
+		return &FBM{Noise: p.Simplex, Scale: c.Scale, Octaves: c.Octaves, Persistence: c.Persistence, Lacunarity: c.Lacunarity}, nil
+	case "ridged":
+		p := newPerlin(rnd)
+		return &Ridged{Noise: p.Noise, Scale: c.Scale, Octaves: c.Octaves, Persistence: c.Persistence, Lacunarity: c.Lacunarity, Offset: c.Offset, Gain: c.Gain}, nil
+	case "diamond":
+		return NewDiamondSquare(rnd, width, height, c.Persistence), nil
+	case "worley":
+		return &Worley{perm: newPerlin(rnd).perm, Scale: c.Scale}, nil
+	}
+	return nil, fmt.Errorf("unknown noise type: %s", c.Type)
+}
+
+// 梯度噪声的置换表
+type perlin struct {
+	perm [512]int
+}
+
+func newPerlin(rnd *rand.Rand) *perlin {
+	p := &perlin{}
+	for i, v := range rnd.Perm(256) {
+		p.perm[i], p.perm[i+256] = v, v
+	}
+	return p
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(t, a, b float64) float64 {
+	return a + t*(b-a)
+}
+
+// 8个方向的梯度
+func grad(hash int, x, y float64) float64 {
+	switch hash & 7 {
+	case 0:
+		return x + y
+	case 1:
+		return -x + y
+	case 2:
+		return x - y
+	case 3:
+		return -x - y
+	case 4:
+		return x
+	case 5:
+		return -x
+	case 6:
+		return y
+	default:
+		return -y
+	}
+}
+
+// Perlin噪声 取值约[-1,1]
+func (p *perlin) Noise(x, y float64) float64 {
+	xf, yf := math.Floor(x), math.Floor(y)
+	xi, yi := int(xf)&255, int(yf)&255
+	x, y = x-xf, y-yf
+	u, v := fade(x), fade(y)
+
+	aa := p.perm[p.perm[xi]+yi]
+	ab := p.perm[p.perm[xi]+yi+1]
+	ba := p.perm[p.perm[xi+1]+yi]
+	bb := p.perm[p.perm[xi+1]+yi+1]
+
+	return lerp(v,
+		lerp(u, grad(aa, x, y), grad(ba, x-1, y)),
+		lerp(u, grad(ab, x, y-1), grad(bb, x-1, y-1)))
+}
+
+var (
+	simplexF2 = 0.5 * (math.Sqrt(3) - 1)
+	simplexG2 = (3 - math.Sqrt(3)) / 6
+)
+
+// 单形噪声 取值约[-1,1] 比Perlin少方向性痕迹
+func (p *perlin) Simplex(x, y float64) float64 {
+	s := (x + y) * simplexF2
+	i, j := math.Floor(x+s), math.Floor(y+s)
+	t := (i + j) * simplexG2
+	x0, y0 := x-(i-t), y-(j-t)
+
+	i1, j1 := 0, 1
+	if x0 > y0 {
+		i1, j1 = 1, 0
+	}
+	x1, y1 := x0-float64(i1)+simplexG2, y0-float64(j1)+simplexG2
+	x2, y2 := x0-1+2*simplexG2, y0-1+2*simplexG2
+
+	ii, jj := int(i)&255, int(j)&255
+	corner := func(hash int, x, y float64) float64 {
+		t := 0.5 - x*x - y*y
+		if t < 0 {
+			return 0
+		}
+		t *= t
+		return t * t * grad(hash, x, y)
+	}
+	n := corner(p.perm[ii+p.perm[jj]], x0, y0) +
+		corner(p.perm[ii+i1+p.perm[jj+j1]], x1, y1) +
+		corner(p.perm[ii+1+p.perm[jj+1]], x2, y2)
+	return 70 * n
+}
+
+// 多倍频分形布朗运动 适合做大陆底图和细节
+type FBM struct {
+	Noise       func(x, y float64) float64
+	Scale       float64
+	Octaves     int
+	Persistence float64
+	Lacunarity  float64
+}
+
+func (f *FBM) Height(x, y int) float64 {
+	var sum, amp, ampSum, freq float64 = 0, 1, 0, 1 / f.Scale
+	for o := 0; o < f.Octaves; o++ {
+		sum += amp * f.Noise(float64(x)*freq, float64(y)*freq)
+		ampSum += amp
+		amp *= f.Persistence
+		freq *= f.Lacunarity
+	}
+	return clamp01((sum/ampSum + 1) / 2)
+}
+
+// 山脊多重分形 |noise|取反后平方 得到尖锐的山脊线
+type Ridged struct {
+	Noise       func(x, y float64) float64
+	Scale       float64
+	Octaves     int
+	Persistence float64
+	Lacunarity  float64
+	Offset      float64
+	Gain        float64
+}
+
+func (r *Ridged) Height(x, y int) float64 {
+	var sum, amp, ampSum, freq, weight float64 = 0, 1, 0, 1 / r.Scale, 1
+	for o := 0; o < r.Octaves; o++ {
+		signal := r.Offset - math.Abs(r.Noise(float64(x)*freq, float64(y)*freq))
+		signal *= signal * weight
+		weight = clamp01(signal * r.Gain)
+		sum += signal * amp
+		ampSum += amp * r.Offset * r.Offset
+		amp *= r.Persistence
+		freq *= r.Lacunarity
+	}
+	return clamp01(sum / ampSum)
+}
+
+// 菱形-正方形算法 预先生成覆盖地图的(2^n+1)网格
+type DiamondSquare struct {
+	size int
+	grid []float64
+}
+
+// roughness 每细分一级随机扰动的衰减 (0,1) 越大越崎岖
+func NewDiamondSquare(rnd *rand.Rand, width, height int, roughness float64) *DiamondSquare {
+	n := 1
+	for n < width || n < height {
+		n *= 2
+	}
+	size := n + 1
+	g := make([]float64, size*size)
+	at := func(x, y int) *float64 { return &g[x+y*size] }
+	for _, c := range [][2]int{{0, 0}, {n, 0}, {0, n}, {n, n}} {
+		*at(c[0], c[1]) = rnd.Float64()
+	}
+
+	disp := 1.0
+	for step := n; step > 1; step /= 2 {
+		half := step / 2
+		// diamond
+		for y := half; y < size; y += step {
+			for x := half; x < size; x += step {
+				avg := (*at(x-half, y-half) + *at(x+half, y-half) + *at(x-half, y+half) + *at(x+half, y+half)) / 4
+				*at(x, y) = avg + (rnd.Float64()*2-1)*disp
+			}
+		}
+		// square
+		for y := 0; y < size; y += half {
+			for x := (y + half) % step; x < size; x += step {
+				sum, cnt := 0.0, 0.0
+				for _, d := range [][2]int{{-half, 0}, {half, 0}, {0, -half}, {0, half}} {
+					nx, ny := x+d[0], y+d[1]
+					if nx >= 0 && nx < size && ny >= 0 && ny < size {
+						sum += *at(nx, ny)
+						cnt++
+					}
+				}
+				*at(x, y) = sum/cnt + (rnd.Float64()*2-1)*disp
+			}
+		}
+		disp *= roughness
+	}
+
+	// 归一化到[0,1]
+	lo, hi := g[0], g[0]
+	for _, v := range g {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if hi > lo {
+		for i := range g {
+			g[i] = (g[i] - lo) / (hi - lo)
+		}
+	}
+	return &DiamondSquare{size: size, grid: g}
+}
+
+func (d *DiamondSquare) Height(x, y int) float64 {
+	return d.grid[x+y*d.size]
+}
+
+// Worley细胞噪声 每个Scale大小的格子里有一个特征点 离特征点越近越高
+type Worley struct {
+	perm  [512]int
+	Scale float64
+}
+
+func (w *Worley) Height(x, y int) float64 {
+	fx, fy := float64(x)/w.Scale, float64(y)/w.Scale
+	cx, cy := int(math.Floor(fx)), int(math.Floor(fy))
+	minDist := math.MaxFloat64
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			ix, iy := cx+dx, cy+dy
+			hx := w.perm[w.perm[ix&255]+(iy&255)]
+			hy := w.perm[w.perm[(ix+97)&255]+(iy&255)]
+			px, py := float64(ix)+float64(hx)/256, float64(iy)+float64(hy)/256
+			dist := (px-fx)*(px-fx) + (py-fy)*(py-fy)
+			if dist < minDist {
+				minDist = dist
+			}
+		}
+	}
+	return clamp01(1 - math.Sqrt(minDist))
+}
+
+func clamp01(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
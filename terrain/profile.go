@@ -0,0 +1,80 @@
+package terrain
+
+import (
+	"fmt"
+	"math"
+)
+
+// hill的海拔剖面 决定从峰顶到边缘高度如何下降
+type Profile uint8
+
+const (
+	ProfileQuartic  Profile = iota // 四次方根 峰尖坡缓 默认
+	ProfileCone                    // 圆锥 线性下降 火山
+	ProfileGaussian                // 高斯 平缓起伏的丘陵
+	ProfileCosine                  // 余弦穹顶 圆润
+	ProfileMesa                    // 平顶山 顶部平坦四周陡崖
+	ProfileCrater                  // 火山口 中间凹陷 外圈隆起
+	ProfileTerrace                 // 梯田 阶梯状
+)
+
+var profileNames = map[string]Profile{
+	"":         ProfileQuartic,
+	"quartic":  ProfileQuartic,
+	"cone":     ProfileCone,
+	"gaussian": ProfileGaussian,
+	"cosine":   ProfileCosine,
+	"dome":     ProfileCosine,
+	"mesa":     ProfileMesa,
+	"plateau":  ProfileMesa,
+	"crater":   ProfileCrater,
+	"terrace":  ProfileTerrace,
+	"terraced": ProfileTerrace,
+}
+
+const (
+	mesaTop       = 0.6 // 平顶山顶部占半径的比例
+	craterRim     = 0.6 // 火山口外圈所在位置
+	craterFloor   = 0.3 // 火山口底部高度比例
+	terraceSteps  = 5   // 梯田的级数
+	gaussianSigma = 4.5 // 高斯剖面的陡度 越大越集中
+)
+
+func ParseProfile(name string) (Profile, error) {
+	if p, ok := profileNames[name]; ok {
+		return p, nil
+	}
+	return ProfileQuartic, fmt.Errorf("unknown hill profile: %s", name)
+}
+
+// t为到峰顶的距离占半径的比例[0,1] 返回高度占H的比例[0,1]
+func (p Profile) Height(t float64) float64 {
+	t = clamp01(t)
+	switch p {
+	case ProfileCone:
+		return 1 - t
+	case ProfileGaussian:
+		edge := math.Exp(-gaussianSigma)
+		return (math.Exp(-gaussianSigma*t*t) - edge) / (1 - edge)
+	case ProfileCosine:
+		return (1 + math.Cos(math.Pi*t)) / 2
+	case ProfileMesa:
+		if t < mesaTop {
+			return 1
+		}
+		return 1 - math.Sqrt((t-mesaTop)/(1-mesaTop))
+	case ProfileCrater:
+		if t < craterRim {
+			r := t / craterRim
+			return craterFloor + (1-craterFloor)*r*r
+		}
+		return 1 - math.Sqrt((t-craterRim)/(1-craterRim))
+	case ProfileTerrace:
+		v := (1 - t) * terraceSteps
+		step := math.Floor(v)
+		// 台面平坦 台阶边缘陡升
+		return (step + math.Pow(v-step, 6)) / terraceSteps
+	default:
+		return 1 - math.Sqrt(t)
+	}
+}
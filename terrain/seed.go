@@ -0,0 +1,14 @@
+package terrain
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// 按阶段名从总seed派生出独立的随机源 同一seed同一阶段得到相同的序列
+// 各阶段互不影响 增减某个阶段的随机次数不会改变其他阶段的结果
+func NewStageRand(seed int64, stage string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(stage))
+	return rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+}
@@ -0,0 +1,123 @@
+package terrain
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// 直方图的分段数
+const StatsHistBins = 256
+
+// 高度图的统计信息
+type Stats struct {
+	Min       float32
+	Max       float32
+	Mean      float32
+	LandRatio float32 // 海平面以上(含)的比例
+	Hist      []int   // 把[Min,Max]等分成StatsHistBins段 每段的点数
+	total     int
+}
+
+// 每行的局部统计 最后按行号顺序合并 结果与并发调度无关
+type rowStats struct {
+	min, max float32
+	sum      float64
+	land     int
+}
+
+// 统计高度图 按行并行归约后合并 不需要锁和channel
+func (m *Topomap) Stats() *Stats {
+	st := &Stats{Hist: make([]int, StatsHistBins), total: len(m.Data)}
+	if len(m.Data) == 0 {
+		return st
+	}
+
+	rows := make([]rowStats, m.Height)
+	m.EachRowBand(func(_, y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := m.Data[y*m.Width : (y+1)*m.Width]
+			rs := rowStats{min: row[0], max: row[0]}
+			for _, h := range row {
+				if h < rs.min {
+					rs.min = h
+				}
+				if h > rs.max {
+					rs.max = h
+				}
+				rs.sum += float64(h)
+				if h >= m.SeaLevel {
+					rs.land++
+				}
+			}
+			rows[y] = rs
+		}
+	})
+
+	var sum float64
+	land := 0
+	st.Min, st.Max = rows[0].min, rows[0].max
+	for _, rs := range rows {
+		if rs.min < st.Min {
+			st.Min = rs.min
+		}
+		if rs.max > st.Max {
+			st.Max = rs.max
+		}
+		sum += rs.sum
+		land += rs.land
+	}
+	st.Mean = float32(sum / float64(len(m.Data)))
+	st.LandRatio = float32(land) / float32(len(m.Data))
+
+	// 直方图需要全局的min/max 第二遍每个行带各自统计 再合并
+	bandHists := make([][]int, runtime.NumCPU())
+	m.EachRowBand(func(bi, y0, y1 int) {
+		hist := make([]int, StatsHistBins)
+		for _, h := range m.Data[y0*m.Width : y1*m.Width] {
+			hist[st.bin(h)]++
+		}
+		bandHists[bi] = hist
+	})
+	for _, hist := range bandHists {
+		for i := range hist {
+			st.Hist[i] += hist[i]
+		}
+	}
+
+	return st
+}
+
+// 高度h所在的直方图分段
+func (st *Stats) bin(h float32) int {
+	bi := int(Level(h, st.Min, st.Max) * StatsHistBins)
+	if bi >= StatsHistBins {
+		bi = StatsHistBins - 1
+	}
+	return bi
+}
+
+// 第p(0-100)百分位的高度 在直方图分段内线性插值
+func (st *Stats) Percentile(p float64) float32 {
+	if st.total == 0 || st.Max <= st.Min {
+		return st.Min
+	}
+	target := p / 100 * float64(st.total)
+	binWidth := (st.Max - st.Min) / StatsHistBins
+	acc := 0
+	for i, n := range st.Hist {
+		if n > 0 && float64(acc+n) >= target {
+			frac := (target - float64(acc)) / float64(n)
+			if frac < 0 {
+				frac = 0
+			}
+			return st.Min + binWidth*(float32(i)+float32(frac))
+		}
+		acc += n
+	}
+	return st.Max
+}
+
+func (st *Stats) String() string {
+	return fmt.Sprintf("min=%.3f max=%.3f mean=%.3f p10=%.3f p50=%.3f p90=%.3f land=%.2f%%",
+		st.Min, st.Max, st.Mean, st.Percentile(10), st.Percentile(50), st.Percentile(90), st.LandRatio*100)
+}
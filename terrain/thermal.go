@@ -0,0 +1,121 @@
+package terrain
+
+import "fmt"
+
+// 热力(崩塌)侵蚀参数 坡度超过休止角的部分向低处滑落 磨平陡崖和锯齿
+type ThermalErosion struct {
+	Iterations int     // 迭代次数 0表示不启用
+	Talus      float64 // 休止角 相邻点允许的最大高度差 对角按距离放大 默认1
+	Rate       float64 // 每次迭代移走超出部分的比例 0-1 默认0.5
+	Stage      string  // before|after 在水力侵蚀之前或之后执行 默认after
+}
+
+const (
+	ThermalBefore = "before"
+	ThermalAfter  = "after"
+)
+
+func (c ThermalErosion) Validate() error {
+	switch c.Stage {
+	case "", ThermalBefore, ThermalAfter:
+		return nil
+	}
+	return fmt.Errorf("unknown thermal erosion stage: %s", c.Stage)
+}
+
+// 是否在stage阶段执行 未指定时在水力侵蚀之后
+func (c ThermalErosion) RunAt(stage string) bool {
+	if c.Iterations <= 0 {
+		return false
+	}
+	if c.Stage == "" {
+		return stage == ThermalAfter
+	}
+	return c.Stage == stage
+}
+
+// 迭代c.Iterations次 每次先按旧高度算出每点滑落的总量 再由每点收集高处邻居滑来的量
+// 两遍都按行带并行 只读旧高度 写自己的点 不需要锁 结果与调度无关 总量守恒
+// 返回所有迭代移动的总量
+func (m *Topomap) ThermalErode(c ThermalErosion) (moved float32) {
+	talus, rate := float32(c.Talus), float32(c.Rate)
+	if talus <= 0 {
+		talus = 1
+	}
+	if rate <= 0 || rate > 1 {
+		rate = 0.5
+	}
+
+	old := make([]float32, len(m.Data))
+	out := make([]float32, len(m.Data))    // 每点滑出的总量
+	excess := make([]float32, len(m.Data)) // 每点超出休止角的高度差之和 用于按比例分配
+	rowMoved := make([]float32, m.Height)
+
+	for it := 0; it < c.Iterations; it++ {
+		copy(old, m.Data)
+		m.EachRowBand(func(_, y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := 0; x < m.Width; x++ {
+					idx := x + y*m.Width
+					var sum, max float32
+					m.eachTalusNeighbor(old, x, y, talus, func(_ int, e float32) {
+						sum += e
+						if e > max {
+							max = e
+						}
+					})
+					// 移走最大超出量的一半乘以速率 滑落后不会比邻居更低
+					excess[idx], out[idx] = sum, rate*max/2
+				}
+			}
+		})
+		m.EachRowBand(func(_, y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				var sum float32
+				for x := 0; x < m.Width; x++ {
+					idx := x + y*m.Width
+					h := old[idx] - out[idx]
+					sum += out[idx]
+					// 高处邻居按超出量的比例分给本点
+					for _, off := range neighbor8 {
+						nx, ny := x+int(off[0]), y+int(off[1])
+						if nx < 0 || ny < 0 || nx >= m.Width || ny >= m.Height {
+							continue
+						}
+						ni := nx + ny*m.Width
+						if out[ni] == 0 {
+							continue
+						}
+						if e := old[ni] - old[idx] - talus*off[2]; e > 0 {
+							h += out[ni] * e / excess[ni]
+						}
+					}
+					m.SetHeight(idx, h) // 堆积的计入沉积层 滑走的先带走沉积层
+				}
+				rowMoved[y] += sum
+			}
+		})
+	}
+	for _, v := range rowMoved {
+		moved += v
+	}
+	return moved
+}
+
+// 8邻居的偏移和距离
+var neighbor8 = [8][3]float32{{1, 0, 1}, {1, 1, 1.4142135}, {0, 1, 1}, {-1, 1, 1.4142135}, {-1, 0, 1}, {-1, -1, 1.4142135}, {0, -1, 1}, {1, -1, 1.4142135}}
+
+// 遍历比(x,y)低且高度差超过休止角的邻居 e为超出的量
+func (m *Topomap) eachTalusNeighbor(data []float32, x, y int, talus float32, fn func(ni int, e float32)) {
+	h := data[x+y*m.Width]
+	for _, off := range neighbor8 {
+		nx, ny := x+int(off[0]), y+int(off[1])
+		if nx < 0 || ny < 0 || nx >= m.Width || ny >= m.Height {
+			continue
+		}
+		ni := nx + ny*m.Width
+		if e := h - data[ni] - talus*off[2]; e > 0 {
+			fn(ni, e)
+		}
+	}
+}
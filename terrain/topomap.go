@@ -0,0 +1,304 @@
+// Package terrain 地形图 由hill/ridge/stuck叠加生成的高度图
+package terrain
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+)
+
+const (
+	RidgeHeightMedian = 7 // ridge 高度中间数 在此基础上浮动
+	HillHeightMedian  = 5 // hill 高度中间数 在此基础上浮动
+)
+
+// 高度的截断策略 决定填充和冲刷后高度允许的范围
+type ClampPolicy int
+
+const (
+	ClampFloor ClampPolicy = iota // 低于0的截断为0 盆地最低到0
+	ClampNone                     // 不截断 允许负海拔
+)
+
+type Topomap struct {
+	Data     []float32 // 对应坐标只保存高度 单位和Hill.H一致
+	Width    int
+	Height   int
+	SeaLevel float32     // 海平面 低于此高度为海
+	Clamp    ClampPolicy // 截断策略
+	Sediment []float32   // 沉积层厚度 被侵蚀时减少 nil表示不记录
+}
+
+// 由名字得到截断策略 floor|none
+func ParseClampPolicy(name string) (ClampPolicy, error) {
+	switch name {
+	case "", "floor":
+		return ClampFloor, nil
+	case "none":
+		return ClampNone, nil
+	}
+	return ClampFloor, fmt.Errorf("unknown clamp policy: %s", name)
+}
+
+func NewTopomap(width, height int) *Topomap {
+	return &Topomap{
+		Data:   make([]float32, width*height),
+		Width:  width,
+		Height: height,
+	}
+}
+
+func (m *Topomap) At(x, y int) float32 {
+	return m.Data[x+y*m.Width]
+}
+
+// 把行切成最多NumCPU个带 每个带一个goroutine fn收到带序号和行范围[y0,y1)
+func (m *Topomap) EachRowBand(fn func(bi, y0, y1 int)) {
+	workerNum := runtime.NumCPU()
+	band := (m.Height + workerNum - 1) / workerNum
+	wg := &sync.WaitGroup{}
+	for bi, y0 := 0, 0; y0 < m.Height; bi, y0 = bi+1, y0+band {
+		y1 := y0 + band
+		if y1 > m.Height {
+			y1 = m.Height
+		}
+		wg.Add(1)
+		go func(bi, y0, y1 int) {
+			defer wg.Done()
+			fn(bi, y0, y1)
+		}(bi, y0, y1)
+	}
+	wg.Wait()
+}
+
+// 按截断策略修正高度
+func (m *Topomap) clamp(h float32) float32 {
+	if m.Clamp == ClampFloor && h < 0 {
+		return 0
+	}
+	return h
+}
+
+// 降低idx处的高度 遵守截断策略
+func (m *Topomap) Lower(idx int, amount float32) {
+	old := m.Data[idx]
+	m.Data[idx] = m.clamp(old - amount)
+	m.wearSediment(idx, old-m.Data[idx])
+}
+
+// 开始记录沉积层
+func (m *Topomap) TrackSediment() {
+	if m.Sediment == nil {
+		m.Sediment = make([]float32, len(m.Data))
+	}
+}
+
+// 在idx处沉积amount 抬高地形并记入沉积层
+func (m *Topomap) Deposit(idx int, amount float32) {
+	m.Data[idx] += amount
+	if m.Sediment != nil {
+		m.Sediment[idx] += amount
+	}
+}
+
+// 侵蚀先带走沉积层 沉积层不会小于0
+func (m *Topomap) wearSediment(idx int, amount float32) {
+	if m.Sediment == nil || amount <= 0 {
+		return
+	}
+	if m.Sediment[idx] -= amount; m.Sediment[idx] < 0 {
+		m.Sediment[idx] = 0
+	}
+}
+
+// 把idx的高度改为h 高度变化计入沉积层 只写idx 可在行带中并行调用
+func (m *Topomap) SetHeight(idx int, h float32) {
+	h = m.clamp(h)
+	if d := h - m.Data[idx]; d > 0 && m.Sediment != nil {
+		m.Sediment[idx] += d
+	} else {
+		m.wearSediment(idx, -d)
+	}
+	m.Data[idx] = h
+}
+
+// 是否在海平面以下
+func (m *Topomap) IsSea(idx int) bool {
+	return m.Data[idx] < m.SeaLevel
+}
+
+// 返回最低和最高海拔
+func (m *Topomap) MinMax() (min, max float32) {
+	if len(m.Data) == 0 {
+		return 0, 0
+	}
+	min, max = m.Data[0], m.Data[0]
+	for _, h := range m.Data {
+		if h < min {
+			min = h
+		}
+		if h > max {
+			max = h
+		}
+	}
+	return min, max
+}
+
+// 将高度线性映射到[lo,hi] 海平面随之映射 平地保持不变
+func (m *Topomap) Normalize(lo, hi float32) {
+	min, max := m.MinMax()
+	if max <= min {
+		return
+	}
+	scale := (hi - lo) / (max - min)
+	for i := range m.Data {
+		m.Data[i] = (m.Data[i]-min)*scale + lo
+	}
+	m.SeaLevel = (m.SeaLevel-min)*scale + lo
+}
+
+// 高度h在[lo,hi]中的比例 截断到[0,1] 用于着色
+func Level(h, lo, hi float32) float32 {
+	if hi <= lo {
+		return 0
+	}
+	l := (h - lo) / (hi - lo)
+	if l < 0 {
+		return 0
+	}
+	if l > 1 {
+		return 1
+	}
+	return l
+}
+
+// 填充时每个tile的行数 每个tile由一个goroutine独占累加 最后合并到m.Data
+const FillTileRows = 64
+
+// 一组hill和它们共用的花瓣参数
+type HillLayer struct {
+	Hills     []Hill
+	PetalFlag *PetalFlag
+}
+
+// 落在某个tile上的hill
+type tileHill struct {
+	hill  *Hill
+	petal *PetalFlag
+}
+
+// 生成地图 制造地形 将各层hill累加到m已有的高度上 返回最大海拔
+// 每个hill只访问它的外接正方形(半径取Hill.Rad) 耗时和hill面积成正比 与地图面积无关
+// 同一像素上的hill按layers和Hills的顺序累加 结果与遍历方式无关
+func (m *Topomap) FillHills(layers ...HillLayer) (maxColor float32) {
+	// 按行把地图切成tile 把每个hill分配到它外接正方形覆盖到的tile上
+	tileNum := (m.Height + FillTileRows - 1) / FillTileRows
+	tiles := make([][]tileHill, tileNum)
+	for li := range layers {
+		for hi := range layers[li].Hills {
+			h := &layers[li].Hills[hi]
+			y0, y1 := h.Y-h.Rad, h.Y+h.Rad
+			if h.Rad <= 0 || y1 < 0 || y0 >= m.Height || h.X+h.Rad < 0 || h.X-h.Rad >= m.Width {
+				continue
+			}
+			if y0 < 0 {
+				y0 = 0
+			}
+			if y1 >= m.Height {
+				y1 = m.Height - 1
+			}
+			for ti := y0 / FillTileRows; ti <= y1/FillTileRows; ti++ {
+				tiles[ti] = append(tiles[ti], tileHill{hill: h, petal: layers[li].PetalFlag})
+			}
+		}
+	}
+
+	tileMax := make([]float32, tileNum)
+	tileChan := make(chan int, tileNum)
+	for ti := range tiles {
+		tileChan <- ti
+	}
+	close(tileChan)
+
+	wgf := &sync.WaitGroup{}
+	workerNum := runtime.NumCPU()
+	wgf.Add(workerNum)
+	for wi := 0; wi < workerNum; wi++ {
+		go func() {
+			defer wgf.Done()
+			buf := make([]float32, FillTileRows*m.Width)
+			for ti := range tileChan {
+				tileMax[ti] = m.fillTile(ti, tiles[ti], buf)
+			}
+		}()
+	}
+	wgf.Wait()
+
+	maxColor = 1
+	for _, tmax := range tileMax {
+		if maxColor < tmax {
+			maxColor = tmax
+		}
+	}
+	log.Printf("fill hills done, tiles=%d maxColor=%f", tileNum, maxColor)
+	return maxColor
+}
+
+// 在buf中累加第ti个tile上的hill 然后合并到m.Data 返回累加过程中出现的最大海拔
+func (m *Topomap) fillTile(ti int, hills []tileHill, buf []float32) (maxColor float32) {
+	tileY0 := ti * FillTileRows
+	tileY1 := tileY0 + FillTileRows
+	if tileY1 > m.Height {
+		tileY1 = m.Height
+	}
+	// 在已有的高度(例如噪声底图)上累加
+	buf = buf[:(tileY1-tileY0)*m.Width]
+	copy(buf, m.Data[tileY0*m.Width:tileY1*m.Width])
+	for _, h := range buf {
+		if maxColor < h {
+			maxColor = h
+		}
+	}
+
+	for _, th := range hills {
+		r := th.hill
+		y0, y1 := r.Y-r.Rad, r.Y+r.Rad
+		if y0 < tileY0 {
+			y0 = tileY0
+		}
+		if y1 >= tileY1 {
+			y1 = tileY1 - 1
+		}
+		x0, x1 := r.X-r.Rad, r.X+r.Rad
+		if x0 < 0 {
+			x0 = 0
+		}
+		if x1 >= m.Width {
+			x1 = m.Width - 1
+		}
+		for y := y0; y <= y1; y++ {
+			row := buf[(y-tileY0)*m.Width:]
+			for x := x0; x <= x1; x++ {
+				distM := (x-r.X)*(x-r.X) + (y-r.Y)*(y-r.Y)
+				if distM > r.Rad*r.Rad {
+					continue
+				}
+				rn := r.R(x, y, th.petal) // 使用花瓣半径效果好
+				if distM <= rn*rn && rn > 0 {
+					// 产生的ring中间隆起
+					row[x] += r.heightAt(x, y, distM, rn)
+					if maxColor < row[x] {
+						maxColor = row[x]
+					}
+				}
+			}
+		}
+	}
+
+	// 合并 tile之间不重叠 不需要加锁
+	for i, h := range buf {
+		m.Data[tileY0*m.Width+i] = m.clamp(h)
+	}
+	return maxColor
+}